@@ -0,0 +1,102 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BuildAssignments returns the SET clause of an UPDATE statement, assigning the values of m to the columns named by its keys, sorted by name:
+//
+//    clause, part := drv.BuildAssignments(map[string]interface{}{"price": 7.5, "product": "apples", "comment": nil})
+//
+//    clause is      SET [comment] = {{p0}}, [price] = {{p1}}, [product] = {{p2}}
+//    part.Text() is SET [comment] = NULL, [price] = 7.5E+00, [product] = 'apples'
+//
+// part is already bound, each value being formatted by the Bind method appropriate for its Go type, as described in BindStruct. A nil value is replaced by NULL.
+// It can be added to a SQLtext, between the UPDATE and WHERE parts:
+//
+//    sqltext := drv.NewSQLtext()
+//    sqltext.Addln(drv.NewSQLpart("UPDATE mydb..items"))
+//    sqltext.Addln(part)
+//    sqltext.Add(drv.NewSQLpart("WHERE orderid = {{id}}").BindInt("id", id))
+//
+// Column names are quoted and must satisfy the rules of BindIdentifier. m cannot be empty.
+// If an error occurs, it is put in part, and can be checked by calling part.Err() method. In this case, clause is empty.
+//
+func BuildAssignments(m map[string]interface{}) (clause string, part *SQLpart) {
+	var (
+		err           error
+		columns       []string
+		textFragments []interface{}
+	)
+
+	part = &SQLpart{}
+
+	if len(m) == 0 {
+		part.err = fmt.Errorf("BuildAssignments: map cannot be empty.")
+		return "", part
+	}
+
+	for column := range m {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns) // deterministic SQL text
+
+	// the SQL text is assembled from fragments, instead of being parsed, so that a column name containing placeholder delimiters is not misinterpreted
+
+	columnTemplate := ParseTemplate("{{c}}")
+	placeholderMap := make(map[string][]int, len(columns))
+	clauseParts := make([]string, len(columns))
+
+	for i, column := range columns {
+		var quotedColumn string
+
+		if quotedColumn, err = columnTemplate.New().BindIdentifier("c", column).Text(); err != nil {
+			part.err = fmt.Errorf("BuildAssignments: column \"%s\": %s", column, err)
+			return "", part
+		}
+
+		param := "p" + strconv.Itoa(i)
+
+		prefix := quotedColumn + " = "
+		if i == 0 {
+			prefix = "SET " + prefix
+		} else {
+			prefix = ", " + prefix
+		}
+
+		textFragments = append(textFragments, prefix, nil)
+		placeholderMap[param] = []int{len(textFragments) - 1}
+		clauseParts[i] = prefix + "{{" + param + "}}"
+	}
+
+	clause = strings.Join(clauseParts, "")
+
+	part.text = clause
+	part.textFragments = textFragments
+	part.placeholderMap = placeholderMap
+
+	// bind values
+
+	for i, column := range columns {
+		param := "p" + strconv.Itoa(i)
+
+		if val := m[column]; val == nil {
+			part.BindNULL(param)
+		} else {
+			part.bindReflectValue(param, reflect.ValueOf(val))
+		}
+	}
+
+	if part.err != nil {
+		return "", part
+	}
+
+	return clause, part
+}
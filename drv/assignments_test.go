@@ -0,0 +1,41 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"testing"
+)
+
+func Test_build_assignments(t *testing.T) {
+	var (
+		err  error
+		text string
+	)
+
+	clause, part := BuildAssignments(map[string]interface{}{"product": "O'Hara", "price": 7.5, "comment": nil, "weird{{x}}]": 3})
+
+	if clause != "SET [comment] = {{p0}}, [price] = {{p1}}, [product] = {{p2}}, [weird{{x}}]]] = {{p3}}" {
+		t.Fatalf("bad clause %q", clause)
+	}
+
+	if text, err = part.Text(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if text != "SET [comment] = NULL, [price] = 7.5E+00, [product] = 'O''Hara', [weird{{x}}]]] = 3" {
+		t.Fatalf("bad text %q", text)
+	}
+
+	if _, part = BuildAssignments(nil); part.Err() == nil {
+		t.Fatalf("error expected for empty map")
+	}
+
+	if clause, part = BuildAssignments(map[string]interface{}{"": 1}); part.Err() == nil || clause != "" {
+		t.Fatalf("error expected for empty column name")
+	}
+
+	if _, part = BuildAssignments(map[string]interface{}{"a": struct{}{}}); part.Err() == nil {
+		t.Fatalf("error expected for unsupported value type")
+	}
+}
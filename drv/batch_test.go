@@ -0,0 +1,830 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"rsql/internal/fakeserver"
+	"rsql/msgp"
+	"rsql/rsqlib"
+)
+
+// newFakeConnection returns a Connection to a fake server, which sends each script as the responses to a batch.
+// The error returned by the fake server is sent to the returned channel.
+//
+func newFakeConnection(t testing.TB, scripts ...[]fakeserver.Response) (*Connection, chan error) {
+
+	client, srv := fakeserver.New()
+	t.Cleanup(func() { srv.Close() })
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(scripts...) }()
+
+	conn, err := NewConnectionWithConn(client, "login=sa;password=x;keepalive=0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	t.Cleanup(conn.Close)
+
+	return conn, done
+}
+
+func Test_fake_server_query(t *testing.T) {
+	var (
+		id    int64
+		name  string
+		price float64
+		day   time.Time
+		names []string
+	)
+
+	columns := []fakeserver.Column{
+		{Name: "id", Datatype: rsqlib.DTYPE_INT},
+		{Name: "name", Datatype: rsqlib.DTYPE_VARCHAR, Precision: 10},
+		{Name: "price", Datatype: rsqlib.DTYPE_FLOAT},
+		{Name: "day", Datatype: rsqlib.DTYPE_DATE},
+	}
+
+	date := time.Date(2017, 3, 14, 0, 0, 0, 0, time.UTC)
+
+	conn, done := newFakeConnection(t, []fakeserver.Response{
+		fakeserver.Message("starting"),
+		fakeserver.Recordset(columns, []interface{}{1, "apple", 1.5, date}, []interface{}{2, "pear", 2.25, date.AddDate(0, 0, 1)}),
+		fakeserver.Print("between"),
+		fakeserver.ExecutionFinished(3),
+		fakeserver.Recordset([]fakeserver.Column{{Name: "n", Datatype: rsqlib.DTYPE_BIGINT}}),
+		fakeserver.BatchEnd(7),
+	})
+
+	b, err := conn.Query("SELECT ...")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for b.Next() {
+		if err = b.Scan(&id, &name, &price, &day); err != nil {
+			t.Fatalf("%s", err)
+		}
+		names = append(names, name)
+	}
+
+	if b.Err() != nil {
+		t.Fatalf("%s", b.Err())
+	}
+
+	if len(names) != 2 || names[1] != "pear" || id != 2 || price != 2.25 || day.Equal(date.AddDate(0, 0, 1)) == false {
+		t.Fatalf("bad records %v %d %v %v", names, id, price, day)
+	}
+
+	if b.Next() { // second recordset is empty
+		t.Fatalf("no record expected")
+	}
+
+	if err = b.Finalize(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if b.RecordsetCount() != 2 || b.ExecRecordCount() != 3 || b.Rc() != 7 {
+		t.Fatalf("bad batch info: %d recordsets, exec count %d, rc %d", b.RecordsetCount(), b.ExecRecordCount(), b.Rc())
+	}
+
+	if w := b.Warnings(); len(w) != 1 || w[0] != "starting" {
+		t.Fatalf("bad warnings %v", w)
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_batch_error(t *testing.T) {
+
+	conn, done := newFakeConnection(t,
+		[]fakeserver.Response{
+			fakeserver.Error("division by zero", 1, 2, 8),
+			fakeserver.BatchEnd(0),
+		},
+		[]fakeserver.Response{
+			fakeserver.ExecutionFinished(1),
+			fakeserver.BatchEnd(0),
+		},
+	)
+
+	_, err := conn.Execute("SELECT 1\nSELECT 1/0")
+
+	be, ok := err.(*BatchError)
+	if ok == false {
+		t.Fatalf("*BatchError expected, got %T %v", err, err)
+	}
+
+	if be.Text != "division by zero" || be.LineNo != 2 || be.LinePos != 8 {
+		t.Fatalf("bad batch error %s", be)
+	}
+
+	if _, err = conn.Execute("UPDATE ..."); err != nil { // the connection can still be used
+		t.Fatalf("%s", err)
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_broken_stream(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		script []fakeserver.Response
+		errmsg string
+	}{
+		{"unknown response type", []fakeserver.Response{
+			fakeserver.Raw(func(mw *msgp.Writer) { mw.WriteUint8(99) }),
+		}, "Batch: unexpected response type 99 from server, client and server versions may not match. Connection has been closed."},
+		{"record count mismatch", []fakeserver.Response{
+			fakeserver.Layout(fakeserver.Column{Name: "a", Datatype: rsqlib.DTYPE_INT}),
+			fakeserver.Record(1),
+			fakeserver.RecordFinished(2),
+		}, "Batch: record count mismatch, server sent 2 but 1 records were received (RSQL bug). Connection has been closed."},
+	}
+
+	for _, tt := range tests {
+		conn, _ := newFakeConnection(t, tt.script)
+
+		_, err := conn.Execute("SELECT a FROM t")
+		if err == nil {
+			t.Fatalf("%s: error expected", tt.name)
+		}
+
+		if _, ok := err.(*BatchError); ok { // not an error of the SQL batch, but a broken communication
+			t.Fatalf("%s: error should not be a *BatchError", tt.name)
+		}
+
+		if err.Error() != tt.errmsg {
+			t.Fatalf("%s: bad error %q", tt.name, err)
+		}
+
+		if conn.isDead == false {
+			t.Fatalf("%s: connection should be dead", tt.name)
+		}
+	}
+}
+
+func Test_fake_server_progress_handler(t *testing.T) {
+	var progress []int64
+
+	conn, done := newFakeConnection(t, []fakeserver.Response{
+		fakeserver.Message("1000 records inserted"),
+		fakeserver.Message("3 rows skipped because of bad dates"), // not a progress message
+		fakeserver.Message("Warning: 12 records inserted"),
+		fakeserver.Print("2000 records inserted"), // PRINT output is never a progress message
+		fakeserver.Message("2500 records inserted"),
+		fakeserver.ExecutionFinished(2500),
+		fakeserver.BatchEnd(0),
+	})
+
+	conn.SetProgressHandler(func(rowsSoFar int64) { progress = append(progress, rowsSoFar) })
+
+	if _, err := conn.Execute("BULK INSERT ..."); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(progress) != 2 || progress[0] != 1000 || progress[1] != 2500 {
+		t.Fatalf("bad progress %v", progress)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_map_scan_slice_scan(t *testing.T) {
+
+	columns := []fakeserver.Column{
+		{Name: "id", Datatype: rsqlib.DTYPE_INT},
+		{Name: "name", Datatype: rsqlib.DTYPE_VARCHAR, Precision: 10},
+		{Name: "", Datatype: rsqlib.DTYPE_BIT},
+	}
+
+	conn, done := newFakeConnection(t, []fakeserver.Response{
+		fakeserver.Recordset(columns, []interface{}{1, "apple", 1}, []interface{}{2, nil, 0}),
+		fakeserver.BatchEnd(0),
+	})
+
+	b, err := conn.Query("SELECT id, name, 1 FROM t")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if _, err = b.SliceScan(); err == nil {
+		t.Fatalf("error expected before Next")
+	}
+
+	if b.Next() == false {
+		t.Fatalf("record expected")
+	}
+
+	row, err := b.SliceScan()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(row) != 3 || row[0] != int64(1) || row[1] != "apple" || row[2] != true {
+		t.Fatalf("bad row %v", row)
+	}
+
+	if b.Next() == false {
+		t.Fatalf("record expected")
+	}
+
+	m := make(map[string]interface{})
+	if err = b.MapScan(m); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(m) != 2 || m["id"] != int64(2) || m["name"] != nil {
+		t.Fatalf("bad map %v", m)
+	}
+
+	if err = b.Finalize(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_slow_query_handler(t *testing.T) {
+	var slow []string
+
+	delay := fakeserver.Raw(func(mw *msgp.Writer) { time.Sleep(150 * time.Millisecond) }) // sends nothing, just delays the responses
+
+	conn, done := newFakeConnection(t,
+		[]fakeserver.Response{delay, fakeserver.ExecutionFinished(1), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.ExecutionFinished(1), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{delay, fakeserver.ExecutionFinished(1), fakeserver.BatchEnd(0)},
+	)
+
+	conn.SetSlowQueryThreshold(50 * time.Millisecond)
+	conn.SetSlowQueryHandler(func(text string, elapsed time.Duration) {
+		if elapsed <= 50*time.Millisecond {
+			t.Errorf("handler called for %s, elapsed %s is below the threshold", text, elapsed)
+		}
+		slow = append(slow, text)
+	})
+
+	for _, text := range []string{"UPDATE slow", "UPDATE fast"} {
+		if _, err := conn.Execute(text); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	conn.SetSlowQueryThreshold(0) // disabled
+
+	if _, err := conn.Execute("UPDATE slow but not detected"); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(slow) != 1 || slow[0] != "UPDATE slow" {
+		t.Fatalf("bad slow queries %q", slow)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_query_scalar(t *testing.T) {
+
+	name := []fakeserver.Column{{Name: "name", Datatype: rsqlib.DTYPE_VARCHAR, Precision: 10}}
+	pair := []fakeserver.Column{{Name: "a", Datatype: rsqlib.DTYPE_INT}, {Name: "b", Datatype: rsqlib.DTYPE_INT}}
+
+	conn, done := newFakeConnection(t,
+		[]fakeserver.Response{fakeserver.Recordset(name, []interface{}{"apple"}), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.Recordset(name), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.Recordset(name, []interface{}{nil}), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{ // only the last recordset is checked
+			fakeserver.Recordset(pair, []interface{}{1, 2}, []interface{}{3, 4}),
+			fakeserver.Recordset(name, []interface{}{"pear"}),
+			fakeserver.BatchEnd(0),
+		},
+		[]fakeserver.Response{
+			fakeserver.Recordset(name, []interface{}{"apple"}),
+			fakeserver.Recordset(name),
+			fakeserver.BatchEnd(0),
+		},
+		[]fakeserver.Response{fakeserver.Recordset(name, []interface{}{"apple"}, []interface{}{"pear"}), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.Recordset(pair, []interface{}{1, 2}), fakeserver.BatchEnd(0)},
+	)
+
+	if val, isnull, err := conn.QueryScalar("SELECT name ..."); err != nil || isnull || val != "apple" {
+		t.Fatalf("value: %v %v %v", val, isnull, err)
+	}
+
+	if val, _, err := conn.QueryScalar("SELECT name ... no row"); err != ErrNoRows {
+		t.Fatalf("ErrNoRows expected, got %v %v", val, err)
+	}
+
+	if val, isnull, err := conn.QueryScalar("SELECT NULL"); err != nil || isnull == false || val != nil {
+		t.Fatalf("NULL: %v %v %v", val, isnull, err)
+	}
+
+	if val, isnull, err := conn.QueryScalar("SELECT a, b ...; SELECT name ..."); err != nil || isnull || val != "pear" {
+		t.Fatalf("multiple recordsets: %v %v %v", val, isnull, err)
+	}
+
+	if val, _, err := conn.QueryScalar("SELECT name ...; SELECT name ... no row"); err != ErrNoRows {
+		t.Fatalf("ErrNoRows expected for empty last recordset, got %v %v", val, err)
+	}
+
+	if _, _, err := conn.QueryScalar("SELECT name ... two rows"); err == nil {
+		t.Fatalf("error expected for two records")
+	}
+
+	if _, _, err := conn.QueryScalar("SELECT a, b ..."); err == nil {
+		t.Fatalf("error expected for two columns")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_execute_returning_int64(t *testing.T) {
+
+	id := []fakeserver.Column{{Name: "id", Datatype: rsqlib.DTYPE_BIGINT}}
+	name := []fakeserver.Column{{Name: "name", Datatype: rsqlib.DTYPE_VARCHAR, Precision: 10}}
+	pair := []fakeserver.Column{{Name: "a", Datatype: rsqlib.DTYPE_INT}, {Name: "b", Datatype: rsqlib.DTYPE_INT}}
+
+	conn, done := newFakeConnection(t,
+		[]fakeserver.Response{ // only the last recordset is checked
+			fakeserver.Recordset(pair, []interface{}{1, 2}, []interface{}{3, 4}),
+			fakeserver.Recordset(name, []interface{}{"apple"}),
+			fakeserver.Recordset(id, []interface{}{42}),
+			fakeserver.BatchEnd(0),
+		},
+		[]fakeserver.Response{fakeserver.Recordset(id, []interface{}{1}), fakeserver.Recordset(id), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.Recordset(id, []interface{}{1}, []interface{}{2}), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.Recordset(id, []interface{}{1}), fakeserver.Recordset(pair, []interface{}{1, 2}), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.ExecutionFinished(1), fakeserver.BatchEnd(0)},
+	)
+
+	if val, err := conn.ExecuteReturningInt64("SELECT a, b ...; SELECT name ...; SELECT SCOPE_IDENTITY()"); err != nil || val != 42 {
+		t.Fatalf("multiple recordsets: %v %v", val, err)
+	}
+
+	if _, err := conn.ExecuteReturningInt64("SELECT id ...; SELECT id ... no row"); err == nil || strings.Contains(err.Error(), "returning one record") == false {
+		t.Fatalf("error expected for missing last record, got %v", err)
+	}
+
+	if _, err := conn.ExecuteReturningInt64("SELECT id ... two rows"); err == nil || strings.Contains(err.Error(), "exactly one record") == false {
+		t.Fatalf("error expected for two last records, got %v", err)
+	}
+
+	if _, err := conn.ExecuteReturningInt64("SELECT id ...; SELECT a, b ..."); err == nil || strings.Contains(err.Error(), "exactly one column") == false {
+		t.Fatalf("error expected for two last columns, got %v", err)
+	}
+
+	if _, err := conn.ExecuteReturningInt64("INSERT ..."); err == nil {
+		t.Fatalf("error expected for no SELECT")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_recordset_count(t *testing.T) {
+
+	columns := []fakeserver.Column{{Name: "a", Datatype: rsqlib.DTYPE_INT}}
+
+	conn, done := newFakeConnection(t, []fakeserver.Response{
+		fakeserver.Recordset(columns, []interface{}{1}),
+		fakeserver.ExecutionFinished(1),
+		fakeserver.Recordset(columns), // empty recordset is counted
+		fakeserver.BatchEnd(0),
+	})
+
+	b, err := conn.Query("SELECT a ...; UPDATE ...; SELECT a ...")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if b.RecordsetCount() != 1 {
+		t.Fatalf("1 recordset expected when Query returns, got %d", b.RecordsetCount())
+	}
+
+	if err = b.ExpectRecordsets(2); err == nil {
+		t.Fatalf("error expected, as batch has not terminated")
+	}
+
+	if err = b.Finalize(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if b.RecordsetCount() != 2 {
+		t.Fatalf("2 recordsets expected, got %d", b.RecordsetCount())
+	}
+
+	if err = b.ExpectRecordsets(2); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if err = b.ExpectRecordsets(3); err == nil { // e.g. a conditional SELECT has not been executed
+		t.Fatalf("error expected for wrong recordset count")
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_rows_slice(t *testing.T) {
+	var rows [][]interface{}
+
+	columns := []fakeserver.Column{{Name: "id", Datatype: rsqlib.DTYPE_INT}, {Name: "name", Datatype: rsqlib.DTYPE_VARCHAR, Precision: 10}}
+
+	conn, done := newFakeConnection(t, []fakeserver.Response{
+		fakeserver.Recordset(columns, []interface{}{1, "apple"}, []interface{}{2, nil}),
+		fakeserver.Recordset(columns, []interface{}{3, "pear"}, []interface{}{4, "plum"}),
+		fakeserver.BatchEnd(0),
+	})
+
+	b, err := conn.Query("SELECT id, name ...; SELECT id, name ...")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for row := range b.RowsSlice() { // stops at the end of the first recordset
+		rows = append(rows, row)
+	}
+
+	if b.Err() != nil {
+		t.Fatalf("%s", b.Err())
+	}
+
+	if len(rows) != 2 || rows[0][0] != int64(1) || rows[0][1] != "apple" || rows[1][0] != int64(2) || rows[1][1] != nil { // each row is a new slice
+		t.Fatalf("bad rows %v", rows)
+	}
+
+	if b.ExistsNextRecordset() == false {
+		t.Fatalf("second recordset expected")
+	}
+
+	for row := range b.RowsSlice() {
+		if row[0] != int64(3) {
+			t.Fatalf("bad row %v", row)
+		}
+		break
+	}
+
+	if b.Next() == false { // the loop has been exited early, reading can continue
+		t.Fatalf("record expected")
+	}
+
+	if id, _ := b.ColInt64(0); id != 4 {
+		t.Fatalf("bad id %d", id)
+	}
+
+	if err = b.Finalize(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_session_info(t *testing.T) {
+
+	conn, _ := newFakeConnection(t)
+
+	if info := conn.SessionInfo(); info.Login != "sa" || info.Database != "" {
+		t.Fatalf("bad session info %+v", info)
+	}
+
+	conn.Close()
+
+	if info := conn.SessionInfo(); info.Login != "sa" { // still available after Close
+		t.Fatalf("bad session info after Close %+v", info)
+	}
+
+	if info := (&Connection{}).SessionInfo(); info != (SessionInfo{}) { // no session, e.g. login has failed
+		t.Fatalf("empty session info expected, got %+v", info)
+	}
+}
+
+func Test_fake_server_dial_retries(t *testing.T) {
+
+	defer func(saved time.Duration) { DIAL_RETRY_BACKOFF = saved }(DIAL_RETRY_BACKOFF)
+	DIAL_RETRY_BACKOFF = time.Millisecond
+
+	tests := []struct {
+		name     string
+		reject   func(srv *fakeserver.Server) error // called for the first rejected connections
+		rejected int
+		loginErr bool // NewConnection must return a *LoginError
+		accepted int  // number of connections accepted by the server
+	}{
+		{"connection dropped once", (*fakeserver.Server).RejectLogin, 1, false, 2},
+		{"connection always dropped", (*fakeserver.Server).RejectLogin, 3, true, 3},
+		{"login failed", (*fakeserver.Server).FailLogin, 1, true, 1},
+	}
+
+	for _, tt := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		accepted := make(chan int, 1)
+
+		go func() {
+			for i := 0; ; i++ {
+				c, err := ln.Accept()
+				if err != nil { // listener closed
+					accepted <- i
+					return
+				}
+
+				srv := fakeserver.NewServer(c)
+				t.Cleanup(func() { srv.Close() })
+
+				if i < tt.rejected {
+					tt.reject(srv)
+					continue
+				}
+
+				srv.Login()
+			}
+		}()
+
+		conn, err := NewConnection("server=" + ln.Addr().String() + ";login=sa;password=x;keepalive=0;dialretries=2")
+
+		if _, ok := err.(*LoginError); ok != tt.loginErr || (err != nil && tt.loginErr == false) {
+			t.Fatalf("%s: bad error %T %v", tt.name, err, err)
+		}
+
+		conn.Close()
+		ln.Close()
+
+		if n := <-accepted; n != tt.accepted {
+			t.Fatalf("%s: %d connections accepted, %d expected", tt.name, n, tt.accepted)
+		}
+	}
+}
+
+func Test_fake_server_context_done(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		srv := fakeserver.NewServer(c)
+		t.Cleanup(func() { srv.Close() })
+
+		srv.Login()
+		srv.ReadBatch()
+		cancel() // the batch never terminates, until the context closes the connection
+	}()
+
+	conn, err := NewConnectionContext(ctx, "server="+ln.Addr().String()+";login=sa;password=x;keepalive=0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer conn.Close()
+
+	b, err := conn.Query("SELECT ...")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if errors.Is(b.Err(), context.Canceled) == false {
+		t.Fatalf("error wrapping context.Canceled expected, got %v", b.Err())
+	}
+
+	if _, err = conn.Execute("SELECT 1"); errors.Is(err, context.Canceled) == false { // connection is dead
+		t.Fatalf("error wrapping context.Canceled expected, got %v", err)
+	}
+}
+
+func Test_fake_server_context_done_during_login(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		expected error
+	}{
+		{"cancelled", context.Canceled},
+		{"deadline", context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.expected == context.DeadlineExceeded {
+			ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}
+
+		go func() {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+
+			c.Read(make([]byte, 1)) // login has started, but is never answered
+			if tt.expected == context.Canceled {
+				cancel()
+			}
+		}()
+
+		_, err = NewConnectionContext(ctx, "server="+ln.Addr().String()+";login=sa;password=x;keepalive=0") // connect timeout is 10 seconds by default
+
+		if errors.Is(err, tt.expected) == false || strings.Contains(err.Error(), "timeout after") {
+			t.Fatalf("%s: error wrapping %v expected, got %v", tt.name, tt.expected, err)
+		}
+
+		cancel()
+		ln.Close()
+	}
+}
+
+func Test_fake_server_query_into(t *testing.T) {
+
+	type Order struct {
+		ID    int     `rsql:"orderid"`
+		Total float64 `rsql:"total"`
+	}
+
+	orders := []fakeserver.Column{{Name: "orderid", Datatype: rsqlib.DTYPE_INT}, {Name: "total", Datatype: rsqlib.DTYPE_FLOAT}}
+	names := []fakeserver.Column{{Name: "name", Datatype: rsqlib.DTYPE_VARCHAR, Precision: 10}}
+
+	conn, done := newFakeConnection(t,
+		[]fakeserver.Response{
+			fakeserver.Recordset(orders, []interface{}{1000, 12.5}, []interface{}{1001, 7.0}),
+			fakeserver.Recordset(names, []interface{}{"discarded"}),
+			fakeserver.BatchEnd(0),
+		},
+		[]fakeserver.Response{fakeserver.Recordset(names, []interface{}{"apple"}, []interface{}{"pear"}), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.Recordset(orders), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.Recordset(names, []interface{}{"apple"}, []interface{}{"pear"}), fakeserver.BatchEnd(0)},
+		[]fakeserver.Response{fakeserver.ExecutionFinished(1), fakeserver.BatchEnd(0)},
+	)
+
+	res, err := QueryInto[Order](conn, "SELECT orderid, total ...; SELECT name ...")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(res) != 2 || res[0] != (Order{1000, 12.5}) || res[1] != (Order{1001, 7.0}) {
+		t.Fatalf("bad orders %+v", res)
+	}
+
+	fruits, err := QueryInto[string](conn, "SELECT name ...")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(fruits) != 2 || fruits[0] != "apple" || fruits[1] != "pear" {
+		t.Fatalf("bad names %q", fruits)
+	}
+
+	if res, err = QueryInto[Order](conn, "SELECT orderid, total ... no row"); err != nil || res == nil || len(res) != 0 {
+		t.Fatalf("empty non-nil slice expected, got %#v %v", res, err)
+	}
+
+	if _, err = QueryInto[int64](conn, "SELECT name ..."); err == nil { // VARCHAR cannot be scanned into int64
+		t.Fatalf("error expected for type mismatch")
+	}
+
+	if _, err = conn.Execute("UPDATE ..."); err != nil { // the batch has been finalized, the connection can still be used
+		t.Fatalf("%s", err)
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+// failingWriter returns an error for each write.
+//
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func Test_fake_server_write_tsv(t *testing.T) {
+	var buff bytes.Buffer
+
+	columns := []fakeserver.Column{
+		{Name: "id", Datatype: rsqlib.DTYPE_INT},
+		{Name: "my\tname", Datatype: rsqlib.DTYPE_VARCHAR, Precision: 20},
+	}
+
+	conn, done := newFakeConnection(t,
+		[]fakeserver.Response{
+			fakeserver.Recordset(columns, []interface{}{1, "a\tb"}, []interface{}{2, nil}, []interface{}{3, "line1\nline2"}),
+			fakeserver.Recordset(columns, []interface{}{4, "x"}),
+			fakeserver.BatchEnd(0),
+		},
+		[]fakeserver.Response{
+			fakeserver.Layout(columns...),
+			fakeserver.Record(1, "a"),
+			fakeserver.RecordFinished(2), // broken stream
+		},
+	)
+
+	b, err := conn.Query("SELECT id, name ...; SELECT id, name ...")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	count, err := b.WriteTSV(&buff)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if expected := "id\tmy\\tname\n1\ta\\tb\n2\t\\N\n3\tline1\\nline2\n"; count != 3 || buff.String() != expected {
+		t.Fatalf("bad TSV, %d records\n%q expected, got\n%q", count, expected, buff.String())
+	}
+
+	if count, err = b.WriteTSV(failingWriter{}); err == nil || err.Error() != "disk full" || count != 1 { // second recordset
+		t.Fatalf("write error expected, got %d %v", count, err)
+	}
+
+	if b.ExistsNextRecordset() {
+		t.Fatalf("no more recordset expected")
+	}
+
+	buff.Reset()
+
+	if _, err = b.WriteTSV(&buff); err == nil || buff.Len() != 0 { // no stale header after the last recordset
+		t.Fatalf("error expected after the last recordset, got %v %q", err, buff.String())
+	}
+
+	if b, err = conn.Query("SELECT id, name ..."); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if count, err = b.WriteTSV(&buff); err == nil || count != 1 || buff.String() != "id\tmy\\tname\n1\ta\n" { // lines read before the error are flushed
+		t.Fatalf("error expected with flushed lines, got %d %v %q", count, err, buff.String())
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("fake server: %s", err)
+	}
+}
+
+func Test_fake_server_warnings_max(t *testing.T) {
+
+	var script []fakeserver.Response
+	for i := 0; i < WARNINGS_MAX+5; i++ {
+		script = append(script, fakeserver.Message(fmt.Sprintf("%d records inserted", i)))
+	}
+	script = append(script, fakeserver.BatchEnd(0))
+
+	conn, done := newFakeConnection(t, script)
+
+	b, err := conn.Execute("BULK INSERT ...")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	w := b.Warnings()
+	if len(w) != WARNINGS_MAX {
+		t.Fatalf("%d warnings expected, got %d", WARNINGS_MAX, len(w))
+	}
+
+	if w[0] != "0 records inserted" || w[WARNINGS_MAX-1] != fmt.Sprintf("%d records inserted", WARNINGS_MAX-1) { // the first messages are kept
+		t.Fatalf("unexpected warnings %q ... %q", w[0], w[WARNINGS_MAX-1])
+	}
+
+	if err = <-done; err != nil {
+		t.Fatalf("%s", err)
+	}
+}
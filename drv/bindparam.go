@@ -4,13 +4,18 @@
 package drv
 
 import (
+	"bytes"
 	"fmt"
 	"math"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+const IDENTIFIER_LENGTH_MAX = 128 // maximum length of an identifier in T-SQL
+
 // BindNULL replaces all occurrences of the specified placeholder by the literal NULL.
 //
 // If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
@@ -50,6 +55,83 @@ func (part *SQLpart) BindBytes(param string, b []byte) *SQLpart {
 	return part
 }
 
+// BindTyped replaces all occurrences of the specified placeholder by a literal binary string cast to the type sqlTypeName.
+// E.g. CAST(0x1234 AS VARBINARY(16))
+//
+// It generalizes BindBytes for columns of opaque types, which need an explicit cast from binary, like spatial types.
+//
+// sqlTypeName is inserted as is in the SQL text. To prevent SQL injection, it can only contain letters, digits and underscores, optionally followed by a length or precision and scale in parentheses, like VARBINARY(16) or NUMERIC(12,2).
+// Else, an error is put in the SQLpart object.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindTyped(param string, sqlTypeName string, rawHex []byte) *SQLpart {
+	var val string
+
+	if part.err != nil {
+		return part
+	}
+
+	if isValidTypeName(sqlTypeName) == false {
+		part.err = fmt.Errorf("param \"%s\": invalid type name \"%s\".", param, sqlTypeName)
+		return part
+	}
+
+	val = "0x"
+	if len(rawHex) > 0 {
+		val = fmt.Sprintf("%#x", rawHex) // print leading 0x
+	}
+
+	part.setParam(param, "CAST("+val+" AS "+sqlTypeName+")") // put error in part.err if any
+
+	return part
+}
+
+// isValidTypeName returns true if name is made of letters, digits and underscores, not starting with a digit, optionally followed by (n) or (p,s).
+//
+func isValidTypeName(name string) bool {
+
+	isDigits := func(s string) bool {
+		for _, c := range []byte(s) {
+			if c < '0' || c > '9' {
+				return false
+			}
+		}
+		return s != ""
+	}
+
+	if i := strings.IndexByte(name, '('); i != -1 { // (n) or (p,s)
+		if strings.HasSuffix(name, ")") == false {
+			return false
+		}
+
+		args := strings.Split(name[i+1:len(name)-1], ",")
+		if len(args) > 2 {
+			return false
+		}
+
+		for _, arg := range args {
+			if isDigits(arg) == false {
+				return false
+			}
+		}
+
+		name = name[:i]
+	}
+
+	if name == "" || len(name) > IDENTIFIER_LENGTH_MAX || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+
+	for _, c := range []byte(name) {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // BindStr replaces all occurrences of the specified placeholder by a literal string.
 // E.g.   'Hello O''Hara'
 //
@@ -74,6 +156,113 @@ func (part *SQLpart) BindStr(param string, s string) *SQLpart {
 	return part
 }
 
+// BindStrBytes is the same as BindStr, but the string is passed as a []byte, e.g. text read from an io.Reader, which avoids a conversion to string.
+// E.g.   'Hello O''Hara'
+//
+// Unlike BindBytes, which creates a binary literal 0x... for VARBINARY columns, it creates a string literal for VARCHAR columns.
+// b is not modified.
+//
+// If b is nil or empty, the replacing value is the empty string ''. To put the NULL constant instead, use BindNULL.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindStrBytes(param string, b []byte) *SQLpart {
+
+	if part.err != nil {
+		return part
+	}
+
+	buff := make([]byte, 0, len(b)+bytes.Count(b, []byte("'"))+2)
+
+	buff = append(buff, '\'')
+	for _, c := range b { // replace all single quote by two single quotes
+		buff = append(buff, c)
+		if c == '\'' {
+			buff = append(buff, '\'')
+		}
+	}
+	buff = append(buff, '\'')
+
+	part.setParam(param, string(buff)) // put error in part.err if any
+
+	return part
+}
+
+// BindIdentifier replaces all occurrences of the specified placeholder by a quoted identifier, such as a table or column name.
+// E.g.   [my table]   or   [odd]]name]
+//
+// The name is enclosed by square brackets, and all closing brackets ] in name are replaced by two closing brackets ]], following T-SQL rules for delimited identifiers.
+// It is not a string literal, and is inserted as is in the SQL text.
+//
+// name must contain only one part. For qualified names like mydb.dbo.orders, each part must be quoted separately.
+// name cannot be empty, cannot contain more than 128 characters, and cannot contain the NUL character. Else, an error is put in the SQLpart object.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindIdentifier(param string, name string) *SQLpart {
+	var val string
+
+	if part.err != nil {
+		return part
+	}
+
+	if name == "" {
+		part.err = fmt.Errorf("param \"%s\": identifier cannot be empty.", param)
+		return part
+	}
+
+	if utf8.RuneCountInString(name) > IDENTIFIER_LENGTH_MAX {
+		part.err = fmt.Errorf("param \"%s\": identifier cannot be longer than %d characters.", param, IDENTIFIER_LENGTH_MAX)
+		return part
+	}
+
+	if strings.IndexByte(name, 0) != -1 {
+		part.err = fmt.Errorf("param \"%s\": identifier cannot contain NUL character.", param)
+		return part
+	}
+
+	val = quoteIdentifier(name)
+
+	part.setParam(param, val) // put error in part.err if any
+
+	return part
+}
+
+// quoteIdentifier encloses name by square brackets, and replaces all closing brackets by two closing brackets.
+//
+func quoteIdentifier(name string) string {
+
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
+
+// QualifyName returns a qualified object name, with each non-empty part quoted as by BindIdentifier, and all parts joined by dots.
+//
+//    drv.QualifyName("mydb", "dbo", "orders")   returns   [mydb].[dbo].[orders]
+//    drv.QualifyName("mydb", "", "orders")      returns   [mydb]..[orders]
+//    drv.QualifyName("orders")                  returns   [orders]
+//
+// An empty part inside the name is kept empty, which gives the mydb..orders form used by RSQL when the schema is omitted.
+// Leading empty parts are ignored, so that QualifyName("", "", "orders") returns [orders].
+//
+// The parts are not checked for length or NUL character. If they come from untrusted input, check them, e.g. with BindIdentifier.
+//
+func QualifyName(parts ...string) string {
+
+	for len(parts) > 0 && parts[0] == "" {
+		parts = parts[1:]
+	}
+
+	quotedParts := make([]string, len(parts))
+
+	for i, part := range parts {
+		if part != "" {
+			quotedParts[i] = quoteIdentifier(part)
+		}
+	}
+
+	return strings.Join(quotedParts, ".")
+}
+
 // BindInt replaces all occurrences of the specified placeholder by a literal integer.
 // E.g. 1234
 //
@@ -325,9 +514,32 @@ func (part *SQLpart) BindTime(param string, t time.Time) *SQLpart {
 	return part
 }
 
+// BindDuration replaces all occurrences of the specified placeholder by a literal time as string, enclosed by single quotes, d being the duration since midnight.
+// E.g. '15:04:05', or '15:04:05.999999999' if the duration has a fractional second, as for BindTime.
+//
+// It is the reverse of ColTimeDuration.
+//
+// If an error occurs (e.g. d is negative or >= 24h), it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindDuration(param string, d time.Duration) *SQLpart {
+
+	if part.err != nil {
+		return part
+	}
+
+	if d < 0 || d >= 24*time.Hour {
+		part.err = fmt.Errorf("param \"%s\": invalid duration %s, must be >= 0 and < 24h.", param, d)
+		return part
+	}
+
+	return part.BindTime(param, time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC).Add(d))
+}
+
 // BindDatetime replaces all occurrences of the specified placeholder by a literal datetime as string, enclosed by single quotes.
 // E.g. '20060102', or '2006-01-02T15:04:05' or '2006-01-02T15:04:05.999999999' if time part is not 0.
 //
+// The date and time of dt are used as-is, as seen in the location of dt, without any timezone conversion. To convert dt to UTC first, use BindDatetimeUTC.
+//
 // If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
 //
 func (part *SQLpart) BindDatetime(param string, dt time.Time) *SQLpart {
@@ -353,6 +565,265 @@ func (part *SQLpart) BindDatetime(param string, dt time.Time) *SQLpart {
 	return part
 }
 
+// BindDatetimeUTC is the same as BindDatetime, but dt is converted to UTC before being formatted.
+// E.g. 2006-01-02 17:04:05 +0200 CEST is replaced by '2006-01-02T15:04:05'.
+//
+// It is the counterpart of ColDatetimeUTC, which returns DATETIME values in UTC.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindDatetimeUTC(param string, dt time.Time) *SQLpart {
+
+	return part.BindDatetime(param, dt.UTC())
+}
+
+// BindBool replaces all occurrences of the specified placeholder by the literal integer 1 if b is true, or 0 if b is false.
+//
+// These literals can be stored in BIT and BOOLEAN columns.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindBool(param string, b bool) *SQLpart {
+
+	if part.err != nil {
+		return part
+	}
+
+	val := "0"
+	if b {
+		val = "1"
+	}
+
+	part.setParam(param, val) // put error in part.err if any
+
+	return part
+}
+
+// BindStruct binds the fields of the struct v that have a `rsql` tag. The tag value is the name of the placeholder.
+// v can be a struct or a pointer to a struct. Fields without tag, or with the tag `rsql:"-"`, are ignored.
+//
+//    type Order struct {
+//        Customer  int       `rsql:"custid"`
+//        OrderDate time.Time `rsql:"odate"`
+//        Total     float64   `rsql:"total"`
+//        Comment   *string   `rsql:"comment"`  // NULL if nil
+//    }
+//
+//    part.BindStruct(&order)
+//
+// The Bind method is chosen according to the type of the field:
+//
+//    signed integers            BindInt64
+//    unsigned integers          BindUint64
+//    float32, float64           BindFloat64
+//    string                     BindStr
+//    []byte                     BindBytes
+//    bool                       BindBool
+//    time.Time                  BindDatetime (which formats a date if time part is 0)
+//    pointer to these types     BindNULL if the pointer is nil
+//
+// For fields of other types, an error is put in the SQLpart object.
+// As reflection cannot read unexported fields, an unexported field with a `rsql` tag is also an error.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindStruct(v interface{}) *SQLpart {
+
+	if part.err != nil {
+		return part
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() == false {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		part.err = fmt.Errorf("BindStruct: argument must be a struct or a pointer to a struct, not %T.", v)
+		return part
+	}
+
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+
+		param := field.Tag.Get("rsql")
+		if param == "" || param == "-" {
+			continue
+		}
+
+		if field.PkgPath != "" { // unexported field
+			part.err = fmt.Errorf("BindStruct: field %s with tag `rsql:\"%s\"` is not exported.", field.Name, param)
+			return part
+		}
+
+		part.bindReflectValue(param, rv.Field(i))
+
+		if part.err != nil {
+			return part
+		}
+	}
+
+	return part
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// bindReflectValue calls the Bind method appropriate for the type of v, as described in BindStruct.
+//
+func (part *SQLpart) bindReflectValue(param string, v reflect.Value) {
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			part.BindNULL(param)
+			return
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == timeType {
+		part.BindDatetime(param, v.Interface().(time.Time))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		part.BindInt64(param, v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		part.BindUint64(param, v.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		part.BindFloat64(param, v.Float())
+
+	case reflect.String:
+		part.BindStr(param, v.String())
+
+	case reflect.Bool:
+		part.BindBool(param, v.Bool())
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			part.err = fmt.Errorf("param \"%s\": type %s not supported.", param, v.Type())
+			return
+		}
+		part.BindBytes(param, v.Bytes())
+
+	default:
+		part.err = fmt.Errorf("param \"%s\": type %s not supported.", param, v.Type())
+	}
+}
+
+//========= Bind methods for pointers =========
+//
+// These methods replace the placeholder by NULL if the pointer is nil. Else, they call the Bind method for the pointed value.
+// They are convenient for optional values.
+
+// BindIntPtr replaces all occurrences of the specified placeholder by NULL if i is nil, else by the literal integer *i, as BindInt does.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindIntPtr(param string, i *int) *SQLpart {
+
+	if i == nil {
+		return part.BindNULL(param)
+	}
+
+	return part.BindInt(param, *i)
+}
+
+// BindInt64Ptr replaces all occurrences of the specified placeholder by NULL if i is nil, else by the literal integer *i, as BindInt64 does.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindInt64Ptr(param string, i *int64) *SQLpart {
+
+	if i == nil {
+		return part.BindNULL(param)
+	}
+
+	return part.BindInt64(param, *i)
+}
+
+// BindStrPtr replaces all occurrences of the specified placeholder by NULL if s is nil, else by the literal string *s, as BindStr does.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindStrPtr(param string, s *string) *SQLpart {
+
+	if s == nil {
+		return part.BindNULL(param)
+	}
+
+	return part.BindStr(param, *s)
+}
+
+// BindFloat64Ptr replaces all occurrences of the specified placeholder by NULL if f is nil, else by the literal float *f, as BindFloat64 does.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindFloat64Ptr(param string, f *float64) *SQLpart {
+
+	if f == nil {
+		return part.BindNULL(param)
+	}
+
+	return part.BindFloat64(param, *f)
+}
+
+// BindBoolPtr replaces all occurrences of the specified placeholder by NULL if b is nil, else by the literal integer 1 or 0 according to *b, as BindBool does.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindBoolPtr(param string, b *bool) *SQLpart {
+
+	if b == nil {
+		return part.BindNULL(param)
+	}
+
+	return part.BindBool(param, *b)
+}
+
+// BindDatePtr replaces all occurrences of the specified placeholder by NULL if d is nil, else by the literal date *d, as BindDate does.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindDatePtr(param string, d *time.Time) *SQLpart {
+
+	if d == nil {
+		return part.BindNULL(param)
+	}
+
+	return part.BindDate(param, *d)
+}
+
+// BindTimePtr replaces all occurrences of the specified placeholder by NULL if t is nil, else by the literal time *t, as BindTime does.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindTimePtr(param string, t *time.Time) *SQLpart {
+
+	if t == nil {
+		return part.BindNULL(param)
+	}
+
+	return part.BindTime(param, *t)
+}
+
+// BindDatetimePtr replaces all occurrences of the specified placeholder by NULL if dt is nil, else by the literal datetime *dt, as BindDatetime does.
+//
+// If an error occurs, it is put in the SQLpart object, and can be checked by calling part.Err() method.
+//
+func (part *SQLpart) BindDatetimePtr(param string, dt *time.Time) *SQLpart {
+
+	if dt == nil {
+		return part.BindNULL(param)
+	}
+
+	return part.BindDatetime(param, *dt)
+}
+
 // setParam replaces all occurrences of the specified placeholder by val.
 //
 // If an error occurs, it is put in part.err.
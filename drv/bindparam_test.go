@@ -0,0 +1,340 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_bind_datetime_utc(t *testing.T) {
+	var (
+		err error
+		res string
+	)
+
+	cest := time.FixedZone("CEST", 2*3600)
+	pst := time.FixedZone("PST", -8*3600)
+
+	var samples = []struct {
+		dt       time.Time
+		expected string
+	}{
+		{time.Date(2006, 1, 2, 17, 4, 5, 0, cest), "'2006-01-02T15:04:05'"},
+		{time.Date(2006, 1, 2, 20, 0, 0, 0, pst), "'2006-01-03T04:00:00'"},
+		{time.Date(2006, 1, 2, 2, 0, 0, 0, cest), "'20060102'"},
+		{time.Date(2006, 1, 2, 1, 0, 0, 500000000, cest), "'2006-01-01T23:00:00.5'"},
+		{time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), "'2006-01-02T15:04:05'"},
+	}
+
+	for _, sample := range samples {
+		if res, err = NewSQLpart("{{dt}}").BindDatetimeUTC("dt", sample.dt).Text(); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if res != sample.expected {
+			t.Fatalf("%s: result %s != %s", sample.dt, res, sample.expected)
+		}
+	}
+}
+
+func Test_bind_datetime_as_is(t *testing.T) {
+	var (
+		err error
+		res string
+	)
+
+	cest := time.FixedZone("CEST", 2*3600)
+
+	if res, err = NewSQLpart("{{dt}}").BindDatetime("dt", time.Date(2006, 1, 2, 17, 4, 5, 0, cest)).Text(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if res != "'2006-01-02T17:04:05'" {
+		t.Fatalf("result %s != %s", res, "'2006-01-02T17:04:05'")
+	}
+}
+
+func Test_bind_identifier(t *testing.T) {
+	var (
+		err error
+		res string
+	)
+
+	var samples = []struct {
+		name     string
+		expected string
+	}{
+		{"orders", "[orders]"},
+		{"my table", "[my table]"},
+		{"odd]name", "[odd]]name]"},
+		{"[x]", "[[x]]]"},
+		{"x]; DROP TABLE t; --", "[x]]; DROP TABLE t; --]"},
+	}
+
+	for _, sample := range samples {
+		if res, err = NewSQLpart("SELECT * FROM {{tbl}}").BindIdentifier("tbl", sample.name).Text(); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if res != "SELECT * FROM "+sample.expected {
+			t.Fatalf("result %s != %s", res, "SELECT * FROM "+sample.expected)
+		}
+	}
+}
+
+func Test_bind_identifier_error(t *testing.T) {
+
+	for _, name := range []string{"", strings.Repeat("a", IDENTIFIER_LENGTH_MAX+1), "a\x00b"} {
+		if err := NewSQLpart("{{tbl}}").BindIdentifier("tbl", name).Err(); err == nil {
+			t.Fatalf("%q: %s", name, "error was expected")
+		}
+	}
+}
+
+func Test_bind_struct(t *testing.T) {
+	var (
+		err error
+		res string
+	)
+
+	comment := "O'Hara"
+
+	type Order struct {
+		Customer  int       `rsql:"custid"`
+		OrderDate time.Time `rsql:"odate"`
+		Total     float64   `rsql:"total"`
+		Paid      bool      `rsql:"paid"`
+		Comment   *string   `rsql:"comment"`
+		Note      *string   `rsql:"note"`
+		Internal  string
+		Ignored   string `rsql:"-"`
+	}
+
+	order := Order{Customer: 123, OrderDate: time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC), Total: 127.5, Paid: true, Comment: &comment}
+
+	text := "{{custid}}, {{odate}}, {{total}}, {{paid}}, {{comment}}, {{note}}"
+	expected := "123, '20060102', 1.275E+02, 1, 'O''Hara', NULL"
+
+	if res, err = NewSQLpart(text).BindStruct(&order).Text(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if res != expected {
+		t.Fatalf("result %s != %s", res, expected)
+	}
+
+	if err = NewSQLpart("{{a}}").BindStruct(struct {
+		A []int `rsql:"a"`
+	}{}).Err(); err == nil {
+		t.Fatalf("error was expected for unsupported type")
+	}
+
+	if err = NewSQLpart("{{a}}").BindStruct(123).Err(); err == nil {
+		t.Fatalf("error was expected for non struct argument")
+	}
+
+	if err = NewSQLpart("{{a}}").BindStruct(struct {
+		a time.Time `rsql:"a"`
+	}{}).Err(); err == nil || strings.Contains(err.Error(), "field a ") == false {
+		t.Fatalf("error naming the unexported field was expected, got %v", err)
+	}
+}
+
+func Test_bind_str_bytes(t *testing.T) {
+	var (
+		err error
+		res string
+	)
+
+	for _, s := range []string{"", "Hello", "Hello O'Hara", "''"} {
+		b := []byte(s)
+
+		if res, err = NewSQLpart("{{s}}").BindStrBytes("s", b).Text(); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		expected, _ := NewSQLpart("{{s}}").BindStr("s", s).Text()
+
+		if res != expected {
+			t.Fatalf("result %s != %s", res, expected)
+		}
+
+		if string(b) != s {
+			t.Fatalf("argument has been modified")
+		}
+	}
+}
+
+func Test_bind_ptr(t *testing.T) {
+	var (
+		err  error
+		res  string
+		nilS *string
+		nilI *int
+	)
+
+	i := 12
+	s := "O'Hara"
+
+	if res, err = NewSQLpart("{{a}}, {{b}}, {{c}}, {{d}}").BindIntPtr("a", &i).BindIntPtr("b", nilI).BindStrPtr("c", &s).BindStrPtr("d", nilS).Text(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if expected := "12, NULL, 'O''Hara', NULL"; res != expected {
+		t.Fatalf("result %s != %s", res, expected)
+	}
+}
+
+func Test_qualify_name(t *testing.T) {
+
+	var samples = []struct {
+		parts    []string
+		expected string
+	}{
+		{[]string{"mydb", "dbo", "orders"}, "[mydb].[dbo].[orders]"},
+		{[]string{"mydb", "", "orders"}, "[mydb]..[orders]"},
+		{[]string{"", "", "orders"}, "[orders]"},
+		{[]string{"odd]db", "my table"}, "[odd]]db].[my table]"},
+		{[]string{"orders"}, "[orders]"},
+		{nil, ""},
+	}
+
+	for _, sample := range samples {
+		if res := QualifyName(sample.parts...); res != sample.expected {
+			t.Fatalf("%q: expected %s, got %s", sample.parts, sample.expected, res)
+		}
+	}
+}
+
+func Test_bind_typed(t *testing.T) {
+	var (
+		err error
+		res string
+	)
+
+	if res, err = NewSQLpart("{{g}}").BindTyped("g", "VARBINARY(16)", []byte{0x12, 0xab}).Text(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if res != "CAST(0x12ab AS VARBINARY(16))" {
+		t.Fatalf("bad result %s", res)
+	}
+
+	if res, err = NewSQLpart("{{g}}").BindTyped("g", "geometry", nil).Text(); err != nil || res != "CAST(0x AS geometry)" {
+		t.Fatalf("bad result %s %v", res, err)
+	}
+
+	for _, typeName := range []string{"", "INT) --", "VARBINARY(16", "NUMERIC(1,2,3)", "NUMERIC()", "1INT", "my type"} {
+		if _, err = NewSQLpart("{{g}}").BindTyped("g", typeName, []byte{1}).Text(); err == nil {
+			t.Fatalf("%q: error was expected", typeName)
+		}
+	}
+}
+
+func Test_must_text(t *testing.T) {
+
+	if res := NewSQLpart("SELECT {{a}}").BindInt("a", 12).MustText(); res != "SELECT 12" {
+		t.Fatalf("bad result %s", res)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("MustText should panic if a placeholder is not filled")
+		}
+	}()
+
+	NewSQLpart("SELECT {{a}}").MustText()
+}
+
+func Test_param_names(t *testing.T) {
+
+	part := NewSQLpart("SELECT {{B}}, {{a}}, '{{x}}' -- {{y}}\nFROM t WHERE c = {{ b }}")
+
+	if names := part.ParamNames(); reflect.DeepEqual(names, []string{"a", "b"}) == false {
+		t.Fatalf("[a b] expected, got %q", names)
+	}
+
+	if names := NewSQLpart("SELECT 1").ParamNames(); names == nil || len(names) != 0 {
+		t.Fatalf("empty list expected, got %q", names)
+	}
+}
+
+func Test_template_literals_comments(t *testing.T) {
+	var (
+		err error
+		res string
+	)
+
+	text := "SELECT {{a}}, '{{not a placeholder}}', 'O''Hara {{x}}'{{b}} -- comment {{c}}\n" +
+		"/* block {{d}} /* nested }} */ still comment {{e}} */ {{a}}"
+
+	expected := "SELECT 1, '{{not a placeholder}}', 'O''Hara {{x}}''z' -- comment {{c}}\n" +
+		"/* block {{d}} /* nested }} */ still comment {{e}} */ 1"
+
+	if res, err = NewSQLpart(text).BindInt("a", 1).BindStr("b", "z").Text(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if res != expected {
+		t.Fatalf("result\n%s\n!=\n%s", res, expected)
+	}
+
+	func() { // a placeholder after the comment is still parsed, and its line number is correct
+		defer func() {
+			if r := recover(); r == nil || strings.Contains(r.(string), "line 4") == false {
+				t.Fatalf("panic on line 4 expected, got %v", r)
+			}
+		}()
+
+		NewSQLpart("-- {{a}}\n'{{b}}\n'\n{{c")
+	}()
+
+	// a quote inside a bracketed identifier doesn't start a string literal
+
+	if res, err = NewSQLpart("SELECT [it's], [a]]{{x}}] FROM t WHERE id = {{id}} AND [{{y}}] = 1").BindInt("id", 5).Text(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if res != "SELECT [it's], [a]]{{x}}] FROM t WHERE id = 5 AND [{{y}}] = 1" {
+		t.Fatalf("bad result %s", res)
+	}
+}
+
+func Test_bind_duration(t *testing.T) {
+	var (
+		err  error
+		text string
+	)
+
+	tmpl := ParseTemplate("SELECT {{d}}")
+
+	tests := []struct {
+		d        time.Duration
+		expected string
+	}{
+		{0, "SELECT '00:00:00'"},
+		{13*time.Hour + 30*time.Minute + 5*time.Second, "SELECT '13:30:05'"},
+		{time.Hour + 500*time.Millisecond, "SELECT '01:00:00.5'"},
+		{24*time.Hour - time.Nanosecond, "SELECT '23:59:59.999999999'"},
+	}
+
+	for _, tt := range tests {
+		if text, err = tmpl.New().BindDuration("d", tt.d).Text(); err != nil {
+			t.Fatalf("%s: %s", tt.d, err)
+		}
+
+		if text != tt.expected {
+			t.Fatalf("%s: %q expected, got %q", tt.d, tt.expected, text)
+		}
+	}
+
+	for _, d := range []time.Duration{-time.Second, 24 * time.Hour} {
+		if _, err = tmpl.New().BindDuration("d", d).Text(); err == nil {
+			t.Fatalf("%s: error expected", d)
+		}
+	}
+}
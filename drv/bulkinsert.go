@@ -0,0 +1,118 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"rsql/rsqlib"
+)
+
+const BULK_INSERT_ROWS_MAX = 1000 // maximum number of rows in a VALUES list, as in MS SQL Server
+
+// BuildBulkInsert returns INSERT statements inserting all rows into table, each statement containing a multi-row VALUES list:
+//
+//    INSERT INTO mydb..items ([orderid], [itemno], [product]) VALUES
+//    (1000, 0, 'chocolate'),
+//    (1000, 1, 'book');
+//
+// It is much faster than sending one INSERT statement per row.
+//
+// table is inserted as-is in the SQL text, so that it can be a qualified name like mydb..items. It must not come from untrusted input.
+// columns are quoted like BindIdentifier does.
+//
+// Each value of rows is formatted by the Bind method appropriate for its Go type, as described in BindStruct. A nil value is replaced by NULL.
+// Each row must contain as many values as columns.
+//
+// A statement contains at most maxRowsPerStatement rows. If maxRowsPerStatement <= 0 or > BULK_INSERT_ROWS_MAX, BULK_INSERT_ROWS_MAX is used.
+// A statement is also never larger than rsqlib.BATCH_TEXT_SIZE_MAX, so that each statement can be sent as a batch.
+// An error is returned if a single row doesn't fit in this size.
+//
+func BuildBulkInsert(table string, columns []string, rows [][]interface{}, maxRowsPerStatement int) ([]string, error) {
+	var (
+		err        error
+		statements []string
+		header     string
+		rowTexts   []string
+	)
+
+	if table == "" {
+		return nil, fmt.Errorf("BuildBulkInsert: table cannot be empty.")
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("BuildBulkInsert: columns cannot be empty.")
+	}
+
+	if maxRowsPerStatement <= 0 || maxRowsPerStatement > BULK_INSERT_ROWS_MAX {
+		maxRowsPerStatement = BULK_INSERT_ROWS_MAX
+	}
+
+	// INSERT INTO table (columns) VALUES
+
+	columnTemplate := ParseTemplate("{{c}}")
+	quotedColumns := make([]string, len(columns))
+
+	for i, column := range columns {
+		if quotedColumns[i], err = columnTemplate.New().BindIdentifier("c", column).Text(); err != nil {
+			return nil, fmt.Errorf("BuildBulkInsert: column %d: %s", i, err)
+		}
+	}
+
+	header = "INSERT INTO " + table + " (" + strings.Join(quotedColumns, ", ") + ") VALUES\n"
+
+	// rows
+
+	valueTemplate := ParseTemplate("{{v}}")
+	size := len(header)
+
+	flush := func() { // create a statement with the pending rows
+		statements = append(statements, header+strings.Join(rowTexts, ",\n")+";")
+		rowTexts = rowTexts[:0]
+		size = len(header)
+	}
+
+	for r, row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("BuildBulkInsert: row %d has %d values, %d expected.", r, len(row), len(columns))
+		}
+
+		values := make([]string, len(row))
+
+		for i, val := range row {
+			part := valueTemplate.New()
+
+			if val == nil {
+				part.BindNULL("v")
+			} else {
+				part.bindReflectValue("v", reflect.ValueOf(val))
+			}
+
+			if values[i], err = part.Text(); err != nil {
+				return nil, fmt.Errorf("BuildBulkInsert: row %d: %s", r, err)
+			}
+		}
+
+		rowText := "(" + strings.Join(values, ", ") + ")"
+
+		if len(header)+len(rowText)+1 >= rsqlib.BATCH_TEXT_SIZE_MAX {
+			return nil, fmt.Errorf("BuildBulkInsert: row %d is too large, statement must be < %d bytes.", r, rsqlib.BATCH_TEXT_SIZE_MAX)
+		}
+
+		if len(rowTexts) == maxRowsPerStatement || size+len(rowText)+2 >= rsqlib.BATCH_TEXT_SIZE_MAX { // 2 for ",\n" or ";"
+			flush()
+		}
+
+		rowTexts = append(rowTexts, rowText)
+		size += len(rowText) + 2
+	}
+
+	if len(rowTexts) > 0 {
+		flush()
+	}
+
+	return statements, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"strings"
+	"testing"
+
+	"rsql/rsqlib"
+)
+
+func Test_build_bulk_insert(t *testing.T) {
+	var (
+		err        error
+		statements []string
+	)
+
+	rows := [][]interface{}{
+		{1000, 0, "chocolate", 100.0},
+		{1000, 1, "O'Hara", nil},
+		{1000, 2, "apples", 7.5},
+	}
+
+	if statements, err = BuildBulkInsert("mydb..items", []string{"orderid", "itemno", "product", "price"}, rows, 2); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := []string{
+		"INSERT INTO mydb..items ([orderid], [itemno], [product], [price]) VALUES\n(1000, 0, 'chocolate', 1E+02),\n(1000, 1, 'O''Hara', NULL);",
+		"INSERT INTO mydb..items ([orderid], [itemno], [product], [price]) VALUES\n(1000, 2, 'apples', 7.5E+00);",
+	}
+
+	if len(statements) != len(expected) {
+		t.Fatalf("statement count %d != %d", len(statements), len(expected))
+	}
+
+	for i := range expected {
+		if statements[i] != expected[i] {
+			t.Fatalf("statement %d:\n%s\n!=\n%s", i, statements[i], expected[i])
+		}
+	}
+}
+
+func Test_build_bulk_insert_size(t *testing.T) {
+	var (
+		err        error
+		statements []string
+	)
+
+	s := strings.Repeat("a", 30000)
+	rows := [][]interface{}{{s}, {s}, {s}, {s}, {s}}
+
+	if statements, err = BuildBulkInsert("t", []string{"a"}, rows, 0); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(statements) != 2 {
+		t.Fatalf("statement count %d != 2", len(statements))
+	}
+
+	for _, statement := range statements {
+		if len(statement) >= rsqlib.BATCH_TEXT_SIZE_MAX {
+			t.Fatalf("statement too large: %d", len(statement))
+		}
+	}
+
+	if _, err = BuildBulkInsert("t", []string{"a"}, [][]interface{}{{strings.Repeat("a", rsqlib.BATCH_TEXT_SIZE_MAX)}}, 0); err == nil {
+		t.Fatalf("error was expected for too large row")
+	}
+
+	if _, err = BuildBulkInsert("t", []string{"a", "b"}, [][]interface{}{{1}}, 0); err == nil {
+		t.Fatalf("error was expected for bad value count")
+	}
+}
+
+func Test_build_bulk_insert_edge_cases(t *testing.T) {
+	var (
+		err        error
+		statements []string
+	)
+
+	if statements, err = BuildBulkInsert("t", []string{"a"}, nil, 2); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(statements) != 0 {
+		t.Fatalf("no statement expected for zero rows, got %q", statements)
+	}
+
+	rows := [][]interface{}{{1}, {2}, {3}, {4}} // exact multiple of maxRowsPerStatement
+
+	if statements, err = BuildBulkInsert("t", []string{"a"}, rows, 2); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := []string{
+		"INSERT INTO t ([a]) VALUES\n(1),\n(2);",
+		"INSERT INTO t ([a]) VALUES\n(3),\n(4);",
+	}
+
+	if len(statements) != len(expected) {
+		t.Fatalf("statement count %d != %d: %q", len(statements), len(expected), statements)
+	}
+
+	for i := range expected {
+		if statements[i] != expected[i] {
+			t.Fatalf("statement %d:\n%s\n!=\n%s", i, statements[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_PORT = 7777 // default port of RSQL server
+
+var SERVER_PORT = DEFAULT_PORT // port used if not specified in the connection string or Config. This value can be changed before Connections are created, e.g. for a test environment running the server on another port.
+
+// Config contains the attributes of a connection string, as typed fields.
+// It is a validated alternative to connection strings created by hand with fmt.Sprintf.
+//
+//    cfg := drv.Config{Server: "localhost", Login: "sa", Password: "changeme", Database: "mydb"}
+//
+//    if conn, err = drv.NewConnectionFromConfig(&cfg); err != nil {
+//        log.Fatalf("%s", err)
+//    }
+//    defer conn.Close()
+//
+// The connection string has no escape mechanism, so values cannot contain ';' or '=', nor leading or trailing spaces.
+//
+type Config struct {
+	Server   string // host name or IP address, without port
+	Port     int    // if 0, SERVER_PORT is used
+	Login    string
+	Password string
+	Database string // can be empty
+
+	TLS         bool   // TLS is not supported by RSQL server. If true, NewConnectionFromConfig returns an error.
+	Timeout     int    // connect timeout in seconds. If 0, CONNECT_TIMEOUT is used. If < 0, there is no timeout.
+	Keepalive   int    // keepalive interval in seconds. If 0, KEEPALIVE_INTERVAL is used. If < 0, keepalive is disabled.
+	DialRetries int    // number of retries if connection or login fails, with exponential backoff starting at DIAL_RETRY_BACKOFF. By default, 0.
+	AppName     string // not sent to the server, as the communication protocol doesn't support it
+}
+
+// ParseDSN parses a connection string (see Connection) into a Config.
+//
+// Login and Database are converted to lower case, as in NewConnection. If the port is not specified, Port is SERVER_PORT.
+//
+func ParseDSN(dsn string) (Config, error) {
+	var (
+		err        error
+		cfg        Config
+		attributes *connStringAttributes
+	)
+
+	if strings.Contains(dsn, "=") == false {
+		return Config{}, fmt.Errorf("Connection string must contain attr=val pairs separated by semicolon.")
+	}
+
+	if attributes, err = splitConnString(dsn); err != nil {
+		return Config{}, err
+	}
+
+	if attributes.serverAddr != "" {
+		host, port, err := net.SplitHostPort(attributes.serverAddr)
+		if err != nil {
+			return Config{}, fmt.Errorf("Connection string: bad server address \"%s\".", attributes.serverAddr)
+		}
+
+		if cfg.Port, err = strconv.Atoi(port); err != nil || cfg.Port <= 0 || cfg.Port > 65535 {
+			return Config{}, fmt.Errorf("Connection string: bad port \"%s\".", port)
+		}
+
+		cfg.Server = host
+	}
+
+	cfg.Login = attributes.login
+	cfg.Password = attributes.password
+	cfg.Database = attributes.database
+
+	cfg.TLS = attributes.tls
+	cfg.DialRetries = attributes.dialRetries
+	cfg.AppName = attributes.appName
+
+	switch {
+	case attributes.connectTimeout == -1: // not specified
+		cfg.Timeout = 0
+	case attributes.connectTimeout == 0: // no timeout
+		cfg.Timeout = -1
+	default:
+		cfg.Timeout = attributes.connectTimeout
+	}
+
+	switch {
+	case attributes.keepalive == -1: // not specified
+		cfg.Keepalive = 0
+	case attributes.keepalive == 0: // disabled
+		cfg.Keepalive = -1
+	default:
+		cfg.Keepalive = attributes.keepalive
+	}
+
+	return cfg, nil
+}
+
+// FormatDSN returns the connection string for cfg.
+// Attributes with default value are omitted.
+//
+// The result is only valid if cfg.Validate() returns nil.
+//
+func (cfg Config) FormatDSN() string {
+	var (
+		items []string
+	)
+
+	port := cfg.Port
+	if port == 0 {
+		port = SERVER_PORT
+	}
+
+	items = append(items, "server="+net.JoinHostPort(cfg.Server, strconv.Itoa(port)))
+	items = append(items, "login="+cfg.Login)
+	items = append(items, "password="+cfg.Password)
+
+	if cfg.Database != "" {
+		items = append(items, "database="+cfg.Database)
+	}
+
+	switch {
+	case cfg.Timeout < 0:
+		items = append(items, "connecttimeout=0")
+	case cfg.Timeout > 0:
+		items = append(items, "connecttimeout="+strconv.Itoa(cfg.Timeout))
+	}
+
+	switch {
+	case cfg.Keepalive < 0:
+		items = append(items, "keepalive=0")
+	case cfg.Keepalive > 0:
+		items = append(items, "keepalive="+strconv.Itoa(cfg.Keepalive))
+	}
+
+	if cfg.DialRetries > 0 {
+		items = append(items, "dialretries="+strconv.Itoa(cfg.DialRetries))
+	}
+
+	if cfg.AppName != "" {
+		items = append(items, "appname="+cfg.AppName)
+	}
+
+	if cfg.TLS {
+		items = append(items, "tls=true")
+	}
+
+	return strings.Join(items, ";")
+}
+
+// Validate checks that cfg can be formatted into a valid connection string.
+//
+func (cfg Config) Validate() error {
+
+	if cfg.Server == "" {
+		return fmt.Errorf("Config: Server cannot be empty.")
+	}
+
+	if cfg.Login == "" {
+		return fmt.Errorf("Config: Login cannot be empty.")
+	}
+
+	if cfg.Password == "" {
+		return fmt.Errorf("Config: Password cannot be empty.")
+	}
+
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("Config: Port %d out of range.", cfg.Port)
+	}
+
+	if cfg.DialRetries < 0 {
+		return fmt.Errorf("Config: DialRetries must be >= 0.")
+	}
+
+	if cfg.TLS {
+		return fmt.Errorf("Config: TLS is not supported by RSQL server.")
+	}
+
+	var fields = []struct {
+		name string
+		val  string
+	}{
+		{"Server", cfg.Server},
+		{"Login", cfg.Login},
+		{"Password", cfg.Password},
+		{"Database", cfg.Database},
+		{"AppName", cfg.AppName},
+	}
+
+	for _, field := range fields {
+		if strings.ContainsAny(field.val, ";=") {
+			return fmt.Errorf("Config: %s cannot contain ';' or '='.", field.name)
+		}
+
+		if strings.TrimSpace(field.val) != field.val {
+			return fmt.Errorf("Config: %s cannot have leading or trailing spaces.", field.name)
+		}
+	}
+
+	return nil
+}
+
+// NewConnectionFromConfig is the same as NewConnection, but the connection attributes are passed as a Config.
+//
+func NewConnectionFromConfig(cfg *Config) (*Connection, error) {
+
+	if cfg == nil {
+		return nil, fmt.Errorf("Config argument cannot be nil.")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewConnection(cfg.FormatDSN())
+}
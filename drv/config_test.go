@@ -0,0 +1,94 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"testing"
+)
+
+func Test_config_dsn(t *testing.T) {
+	var (
+		err error
+		cfg Config
+	)
+
+	var samples = []struct {
+		cfg      Config
+		expected string
+	}{
+		{Config{Server: "localhost", Login: "sa", Password: "changeme"}, "server=localhost:7777;login=sa;password=changeme"},
+		{Config{Server: "10.0.0.1", Port: 8000, Login: "john", Password: "Secret", Database: "mydb", Timeout: 5, Keepalive: 15, AppName: "MyApp"},
+			"server=10.0.0.1:8000;login=john;password=Secret;database=mydb;connecttimeout=5;keepalive=15;appname=MyApp"},
+		{Config{Server: "localhost", Login: "sa", Password: "changeme", Timeout: -1}, "server=localhost:7777;login=sa;password=changeme;connecttimeout=0"},
+		{Config{Server: "localhost", Login: "sa", Password: "changeme", Keepalive: -1}, "server=localhost:7777;login=sa;password=changeme;keepalive=0"},
+		{Config{Server: "localhost", Login: "sa", Password: "changeme", DialRetries: 3}, "server=localhost:7777;login=sa;password=changeme;dialretries=3"},
+	}
+
+	for _, sample := range samples {
+		if err = sample.cfg.Validate(); err != nil {
+			t.Fatalf("%v: %s", sample.cfg, err)
+		}
+
+		dsn := sample.cfg.FormatDSN()
+		if dsn != sample.expected {
+			t.Fatalf("expected %s, got %s", sample.expected, dsn)
+		}
+
+		if cfg, err = ParseDSN(dsn); err != nil {
+			t.Fatalf("%s: %s", dsn, err)
+		}
+
+		expectedCfg := sample.cfg
+		if expectedCfg.Port == 0 {
+			expectedCfg.Port = SERVER_PORT
+		}
+
+		if cfg != expectedCfg {
+			t.Fatalf("round trip: expected %v, got %v", expectedCfg, cfg)
+		}
+	}
+}
+
+func Test_config_error(t *testing.T) {
+
+	var samples = []Config{
+		{Login: "sa", Password: "changeme"},
+		{Server: "localhost", Login: "sa", Password: "change;me"},
+		{Server: "localhost", Login: "sa", Password: "changeme", Database: " mydb"},
+		{Server: "localhost", Login: "sa", Password: "changeme", Port: 70000},
+		{Server: "localhost", Login: "sa", Password: "changeme", TLS: true},
+	}
+
+	for _, sample := range samples {
+		if err := sample.Validate(); err == nil {
+			t.Fatalf("%v: error expected", sample)
+		}
+	}
+
+	for _, dsn := range []string{"server=localhost;tls=true", "server=localhost;keepalive=-1", "server=localhost:abc"} {
+		if _, err := ParseDSN(dsn); err == nil {
+			t.Fatalf("%s: error expected", dsn)
+		}
+	}
+}
+
+func Test_server_port(t *testing.T) {
+
+	defer func(port int) { SERVER_PORT = port }(SERVER_PORT)
+
+	SERVER_PORT = 17777
+
+	cfg, err := ParseDSN("server=localhost;login=sa;password=changeme")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if cfg.Port != 17777 {
+		t.Fatalf("port 17777 expected, got %d", cfg.Port)
+	}
+
+	if dsn := (Config{Server: "localhost", Login: "sa", Password: "changeme"}).FormatDSN(); dsn != "server=localhost:17777;login=sa;password=changeme" {
+		t.Fatalf("bad dsn %s", dsn)
+	}
+}
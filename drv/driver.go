@@ -194,18 +194,42 @@ The sample code below shows how to use the driver.
 package drv
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"rsql/rsqlib"
 )
 
 var KEEPALIVE_INTERVAL = 20 // in seconds, 20 is default value. This value can be changed before Connections are created.
 
+var DIAL_RETRY_BACKOFF = 250 * time.Millisecond // wait before the first retry to connect, if the "dialretries" attribute of the connection string is > 0. It is doubled for each retry. This value can be changed before Connections are created.
+
+const SERVER_IDLE_TIMEOUT = 30 * time.Second // RSQL server closes connections from which it has received nothing for this duration
+
+var PROGRESS_MESSAGE_PATTERN = regexp.MustCompile(`^(\d+) records? inserted\.?$`) // progress message sent by BULK INSERT, the first group is the record count. Other messages never call the progress handler. This value can be changed before batches are sent.
+
+var CONNECT_TIMEOUT = 10 // in seconds, 10 is default value. It can be changed by the "connecttimeout" attribute of the connection string. This value can be changed before Connections are created.
+
 // Connection contains the attributes needed to establish a connection with the database server.
 //
-//    The connection string format is: "Server=myServerAddress:port;Database=myDataBase;Login=myUsername;Password=myPassword"
-//    Port and Database attributes can be omitted.
+//    The connection string format is: "Server=myServerAddress:port;Database=myDataBase;Login=myUsername;Password=myPassword;ConnectTimeout=10"
+//    Port, Database and ConnectTimeout attributes can be omitted. If Port is omitted, SERVER_PORT is used, which is DEFAULT_PORT (7777) unless changed.
+//
+//    ConnectTimeout is in seconds. It limits the time to connect to the server and to log in. By default, it is 10 seconds. If 0, there is no timeout.
+//    Keepalive is the keepalive interval in seconds. By default, it is 20 seconds. If 0, keepalive is disabled, which is useful for short-lived connections.
+//    DialRetries is the number of times the connection and login are retried if they fail, e.g. because the server is restarting during a deployment. By default, it is 0.
+//    The wait before each retry starts at DIAL_RETRY_BACKOFF and doubles each time. All the attempts, including the waits, are limited by ConnectTimeout. A login rejected by the server is not retried, but a connection dropped during login is, as it may be transient.
+//    AppName is accepted, but it is not sent to the server, as the communication protocol doesn't support it.
+//    TLS can only be "false", as the communication protocol doesn't support TLS.
+//
+//    Instead of writing the connection string by hand, you can fill a Config struct and call NewConnectionFromConfig.
 //
 type Connection struct {
 	connString string
@@ -213,11 +237,24 @@ type Connection struct {
 	serverAddr string
 	login      string // in lower case
 	password   string
-	database   string // in lower case
+	database   string // in lower case. Changed by Use.
 
-	keepalive_interval int             // in seconds. By default, 20 seconds.
+	keepalive_interval int             // in seconds. By default, 20 seconds. If 0, keepalive is disabled.
+	connect_timeout    int             // in seconds. By default, 10 seconds.
+	dial_retries       int             // number of retries if connection or login fails. By default, 0.
 	session            *rsqlib.Session // it is the real connection to the server
+	ctx                context.Context // context of NewConnectionContext, which closes the session when done. nil if it can never be done.
 	isDirty            bool            // last batch is still running or has not cleanly terminated. Connection cannot be used for another batch.
+	isDead             bool            // connection has been closed by Batch.Discard, by the server after an error with state 127, or because ctx is done. Connection cannot be used any more.
+	databaseChanged    bool            // set by Use. The database of the session, requested at login, is obsolete.
+
+	progressHandler func(rowsSoFar int64) // called when the server sends a progress message, e.g. during BULK INSERT. Can be nil.
+	messageHandler  func(msg Message)     // called for each PRINT output and informative message. Can be nil.
+
+	slowQueryThreshold time.Duration                            // if > 0, slowQueryHandler is called for batches running longer than this duration
+	slowQueryHandler   func(text string, elapsed time.Duration) // can be nil
+
+	timeLocation *time.Location // location of DATE and DATETIME values returned by Scan and ColValue. If nil, time.Local.
 }
 
 // connStringAttributes is the connection string, split up into attribute and value pairs.
@@ -228,6 +265,12 @@ type connStringAttributes struct {
 	login      string
 	password   string
 	database   string
+
+	connectTimeout int    // -1 if not specified
+	keepalive      int    // -1 if not specified
+	dialRetries    int    // 0 if not specified
+	tls            bool   // always false, as TLS is not supported
+	appName        string // accepted but not sent to the server
 }
 
 // status is the internal state of execution of the batch.
@@ -249,34 +292,133 @@ const (
 type Batch struct {
 	conn *Connection
 
-	text string // original SQL text
+	text      string    // original SQL text
+	startTime time.Time // time when the batch was sent, to detect slow queries
 
 	status          status
-	recordsetCount  int
+	recordsetCount  int // number of recordsets received so far
 	colnameList     []string
 	colnameMap      map[string]int // column name to field position in record
 	record          []rsqlib.IField
-	recordCount     int64 // record count for SELECT statement
-	execRecordCount int64 // record count for statements like INSERT, UDDATE, DELETE, etc
-	err             error // if an error occurs, the client should close the connection which is useless as it still contains pending information. err can be a *BatchError, which is an error that occurred during batch execution (syntax error, division by 0, duplicate in unique index, etc).
-	rc              int64 // return code of batch
+	prevRecord      []rsqlib.IField // record of the previous recordset, whose fields are reused if the next recordset has the same layout
+	recordCount     int64           // record count for SELECT statement
+	execRecordCount int64           // record count for statements like INSERT, UDDATE, DELETE, etc
+	execCountFound  bool            // true if the server has sent at least one execRecordCount. Not sent if SET NOCOUNT ON.
+	execCountTotal  int64           // sum of all execRecordCount received
+	trimFixedChar   bool            // remove padding spaces of CHAR values
+	rejectNonFinite bool            // NaN and Infinite FLOAT values are an error
+	dateLayout      string          // layouts used by ColString for DATE, TIME and DATETIME columns. If empty, the default layout is used.
+	timeLayout      string
+	datetimeLayout  string
+	err             error    // if an error occurs, the client should close the connection which is useless as it still contains pending information. err can be a *BatchError, which is an error that occurred during batch execution (syntax error, division by 0, duplicate in unique index, etc).
+	rc              int64    // return code of batch
+	warnings        []string // texts of informative messages, at most WARNINGS_MAX
 }
 
 // NewConnection returns a new Connection object.
 // A connection is established with the server.
 //
+// If the server rejects the login, the error is a *LoginError.
+//
 //    RSQL server closes connections that are idle for more than 30 seconds.
 //    So, there should be no pause between consecutive batches on the same connection.
 //    Else, close the connection and open a new one later when needed.
 //
 func NewConnection(connectionString string) (*Connection, error) {
+
+	return NewConnectionWithOptions(connectionString, Options{})
+}
+
+// Options contains options for the server, which are useful for debugging or for validation tools.
+//
+// The communication protocol only supports options sent at login time. They cannot be changed for a single batch.
+// So, to run a batch with specific options (e.g. NoExec to just check the syntax of a batch), open a short-lived connection with NewConnectionWithOptions, and close it when the batch is finished.
+//
+type Options struct {
+	Showtree bool // the server shows the AST tree
+	NoCf     bool // no constant folding
+	NoExec   bool // the server doesn't run the batches, it just checks them
+}
+
+// NewConnectionWithOptions is the same as NewConnection, but the options opt are sent to the server at login.
+// These options apply to all batches sent on this connection.
+//
+// Example, to check the syntax of a batch without executing it:
+//
+//	if conn, err = drv.NewConnectionWithOptions("server=localhost;login=sa;password=changeme;database=mydb", drv.Options{NoExec: true}); err != nil {
+//		log.Fatalf("%s", err)
+//	}
+//	defer conn.Close()
+//
+//	if b, err = conn.Execute(text); err != nil {
+//		log.Fatalf("%s", err) // e.g. syntax error
+//	}
+//
+func NewConnectionWithOptions(connectionString string, options Options) (*Connection, error) {
+
+	return newConnection(context.Background(), connectionString, options)
+}
+
+// NewConnectionContext is the same as NewConnection, but the connection is bound to ctx.
+//
+// The connection and login are cancelled if ctx is cancelled or its deadline expires, in addition to the connect timeout.
+// Once connected, the connection is closed when ctx is done, as by Close: the keepalive goroutine stops, and a running batch fails with an error wrapping ctx.Err(). The connection cannot be used any more.
+// It is useful for request-scoped connections in servers, so that no goroutine nor socket is leaked when the request is cancelled.
+//
+func NewConnectionContext(ctx context.Context, connectionString string) (*Connection, error) {
+
+	if ctx == nil {
+		return nil, fmt.Errorf("Context argument cannot be nil.")
+	}
+
+	return newConnection(ctx, connectionString, Options{})
+}
+
+// NewConnectionWithConn is the same as NewConnection, but the login is performed on netConn, which is already connected to the server, instead of dialing a TCP connection.
+//
+// It is useful for tests, with a fake server at the other end of a net.Pipe, and to connect through a tunnel, e.g. a connection forwarded by SSH.
+// The server attribute of the connection string is not used, and dialretries has no effect. The connect timeout applies to the login.
+//
+// The Connection owns netConn, which is closed by conn.Close(). If an error occurs, netConn is closed.
+//
+func NewConnectionWithConn(netConn net.Conn, connectionString string) (*Connection, error) {
+	var (
+		err     error
+		conn    *Connection
+		session *rsqlib.Session
+	)
+
+	if conn, err = parseConnectionString(connectionString); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	opt := rsqlib.Options{}
+
+	if conn.connect_timeout > 0 {
+		if err = netConn.SetDeadline(time.Now().Add(time.Duration(conn.connect_timeout) * time.Second)); err != nil { // removed by rsqlib.ConnectWithConn after login
+			netConn.Close()
+			return nil, fmt.Errorf("Connection: %s", err)
+		}
+	}
+
+	if session, err = rsqlib.ConnectWithConn(netConn, conn.login, conn.password, conn.database, &opt, conn.keepalive_interval); err != nil {
+		return nil, conn.connectError(nil, err)
+	}
+
+	conn.session = session
+	conn.isDirty = false
+
+	return conn, nil
+}
+
+// parseConnectionString creates a Connection, not yet connected, with the attributes of the connection string.
+//
+func parseConnectionString(connectionString string) (*Connection, error) {
 	var (
 		err        error
 		conn       *Connection
 		attributes *connStringAttributes
-
-		session *rsqlib.Session
-		opt     rsqlib.Options
 	)
 
 	// connection string must contain at least one attr=val pair
@@ -300,15 +442,62 @@ func NewConnection(connectionString string) (*Connection, error) {
 	conn.database = attributes.database
 
 	conn.keepalive_interval = KEEPALIVE_INTERVAL // in seconds, default value
+	if attributes.keepalive != -1 {
+		conn.keepalive_interval = attributes.keepalive
+	}
+
+	conn.connect_timeout = CONNECT_TIMEOUT // in seconds, default value
+	if attributes.connectTimeout != -1 {
+		conn.connect_timeout = attributes.connectTimeout
+	}
+
+	conn.dial_retries = attributes.dialRetries
+
+	return conn, nil
+}
+
+// newConnection creates a Connection, whose connection and login can be cancelled by parentCtx, and which is closed when parentCtx is done.
+//
+func newConnection(parentCtx context.Context, connectionString string, options Options) (*Connection, error) {
+	var (
+		err  error
+		conn *Connection
+
+		session *rsqlib.Session
+		opt     rsqlib.Options
+	)
+
+	if conn, err = parseConnectionString(connectionString); err != nil {
+		return nil, err
+	}
 
 	// open the connection
 
-	opt = rsqlib.Options{} // empty option object
+	opt = rsqlib.Options{
+		Showtree: options.Showtree,
+		No_cf:    options.NoCf,
+		No_exec:  options.NoExec,
+	}
+
+	// send login info to server. The connect timeout applies to all attempts.
+
+	ctx := parentCtx
+
+	if conn.connect_timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(conn.connect_timeout)*time.Second)
+		defer cancel()
+	}
+
+	if session, err = conn.connectWithRetries(ctx, &opt); err != nil { // expects RESTYP_LOGIN_SUCCESS
+		return nil, conn.connectError(parentCtx, err)
+	}
 
-	// send login info to server
+	session.Close_on_done(parentCtx) // does nothing for context.Background()
 
-	if session, err = rsqlib.Connect(conn.serverAddr, conn.login, conn.password, conn.database, &opt, conn.keepalive_interval); err != nil { // expects RESTYP_LOGIN_SUCCESS
-		return nil, fmt.Errorf("Connection: login failed.") // because err is just "EOF", as server dropped the connection when login failed
+	if parentCtx.Done() != nil {
+		conn.ctx = parentCtx
 	}
 
 	conn.session = session // it is the real connection to the server
@@ -317,6 +506,64 @@ func NewConnection(connectionString string) (*Connection, error) {
 	return conn, nil
 }
 
+// contextError returns nil if the context of the connection is not done.
+// Else, the session has been closed by the context, so the connection is marked as dead, and an error wrapping the context error is returned, which replaces the network error caused by the closing.
+//
+func (conn *Connection) contextError() error {
+
+	if conn.ctx == nil || conn.ctx.Err() == nil {
+		return nil
+	}
+
+	conn.isDead = true
+
+	return fmt.Errorf("Batch: connection has been closed, as its context is done: %w", conn.ctx.Err())
+}
+
+// connectError converts an error returned by rsqlib.ConnectContext or rsqlib.ConnectWithConn into the error returned by NewConnection.
+// If parentCtx, the context passed by the caller, is done, the error wraps parentCtx.Err(), so that it is not mistaken for the connect timeout. parentCtx can be nil.
+//
+func (conn *Connection) connectError(parentCtx context.Context, err error) error {
+
+	if parentCtx != nil && parentCtx.Err() != nil {
+		return fmt.Errorf("Connection: context is done: %w", parentCtx.Err())
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return fmt.Errorf("Connection: timeout after %d seconds.", conn.connect_timeout)
+	}
+	if lerr, ok := err.(*rsqlib.Login_error); ok {
+		return &LoginError{Message: lerr.Message()}
+	}
+	return fmt.Errorf("Connection: %s", err) // network error
+}
+
+// connectWithRetries calls rsqlib.ConnectContext, and retries conn.dial_retries times if it fails, with exponential backoff.
+// A login rejected by RESTYP_LOGIN_FAILED is not retried. But if the server has just closed the connection during the handshake, it is retried, as it may be a transient failure.
+//
+func (conn *Connection) connectWithRetries(ctx context.Context, opt *rsqlib.Options) (*rsqlib.Session, error) {
+
+	backoff := DIAL_RETRY_BACKOFF
+
+	for attempt := 0; ; attempt++ {
+		session, err := rsqlib.ConnectContext(ctx, conn.serverAddr, conn.login, conn.password, conn.database, opt, conn.keepalive_interval)
+		if err == nil || attempt >= conn.dial_retries || ctx.Err() != nil {
+			return session, err
+		}
+
+		if _, ok := err.(*rsqlib.Login_error); ok && errors.Is(err, io.EOF) == false { // rejected by RESTYP_LOGIN_FAILED, not a transient error
+			return nil, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		backoff *= 2
+	}
+}
+
 // ConnectionString returns the original connection string.
 //
 func (conn *Connection) ConnectionString() string {
@@ -325,22 +572,239 @@ func (conn *Connection) ConnectionString() string {
 }
 
 // KeepaliveInterval returns the keepalive interval, in seconds.
-// The driver sends periodically a message to the server to signal that it is alive.
+// The driver sends periodically a message to the server to signal that it is alive. If 0, keepalive is disabled.
 //
 func (conn *Connection) KeepaliveInterval() int {
 
 	return conn.keepalive_interval
 }
 
+// SessionInfo contains information about the session, as returned by Connection.SessionInfo.
+//
+type SessionInfo struct {
+	Login    string // in lower case
+	Database string // current database, as set by the connection string or by Use. Empty for the default database of the login.
+}
+
+// SessionInfo returns information about the session, available after login.
+//
+// The server sends no information after login, e.g. no session id, so SessionInfo only reports what the client knows.
+// It can still be called after Close. For a Connection without session, e.g. one whose login has failed, it returns an empty SessionInfo.
+//
+func (conn *Connection) SessionInfo() SessionInfo {
+
+	if conn == nil || conn.session == nil {
+		return SessionInfo{}
+	}
+
+	info := conn.session.Info()
+
+	if conn.databaseChanged { // the database requested at login is obsolete
+		info.Database = conn.database
+	}
+
+	return SessionInfo{
+		Login:    info.Login_name,
+		Database: info.Database,
+	}
+}
+
+// IsUsable returns true if the connection can be used to send another batch.
+//
+// It returns false if the last batch is still running or has not cleanly terminated (e.g. a network error occurred, or Finalize has not been called on a partially read batch),
+// or if the connection has been closed by Batch.Discard or by the server (after a *BatchError with state 127).
+//
+// It is useful to write a connection pool, to decide if a connection returned to the pool can be reused or must be closed.
+//
+func (conn *Connection) IsUsable() bool {
+
+	return conn.isDirty == false && conn.isDead == false
+}
+
+// LastActivity returns the time of the last message sent to the server on this connection, which is a batch, a keepalive message, or the login.
+// The keepalive goroutine updates it each time it sends a keepalive message.
+//
+// If the connection has no session, the zero time is returned.
+//
+func (conn *Connection) LastActivity() time.Time {
+
+	if conn.session == nil {
+		return time.Time{}
+	}
+
+	return conn.session.Last_activity()
+}
+
+// SafeToReuse returns true if the connection is usable (see IsUsable), and if it has sent a message to the server less than maxIdle ago.
+//
+// The server closes connections which are idle for SERVER_IDLE_TIMEOUT (30 seconds), and keepalive messages are sent every KeepaliveInterval (20 seconds).
+// If the keepalive goroutine has stopped (e.g. keepalive disabled, or a write error), a connection taken from a pool just before SERVER_IDLE_TIMEOUT may be closed by the server while the next batch is sent.
+// maxIdle must leave a margin for the time between the check and the next batch, e.g. SERVER_IDLE_TIMEOUT - 5*time.Second. Values larger than SERVER_IDLE_TIMEOUT are reduced to SERVER_IDLE_TIMEOUT.
+//
+//    if conn.SafeToReuse(drv.SERVER_IDLE_TIMEOUT - 5*time.Second) == false {
+//        conn.Close()
+//        conn, err = drv.NewConnection(connString)
+//    }
+//
+func (conn *Connection) SafeToReuse(maxIdle time.Duration) bool {
+
+	if conn.IsUsable() == false || conn.session == nil {
+		return false
+	}
+
+	if maxIdle > SERVER_IDLE_TIMEOUT {
+		maxIdle = SERVER_IDLE_TIMEOUT
+	}
+
+	return time.Since(conn.LastActivity()) < maxIdle
+}
+
+// ConnectTimeout returns the timeout to connect to the server and to log in, in seconds.
+//
+func (conn *Connection) ConnectTimeout() int {
+
+	return conn.connect_timeout
+}
+
+// SetProgressHandler sets a function which is called each time the server sends a progress message with a record count, e.g. when BULK INSERT periodically sends the number of records inserted so far.
+// The handler is called from the goroutine reading the batch (Query, Execute, Next, Finalize), with the record count found in the message.
+//
+// The protocol has no dedicated response type for progress. The server sends these progress messages as RESTYP_MESSAGE text, at a cadence decided by the server (e.g. every few thousands of records for BULK INSERT).
+// Only the messages whose whole text matches PROGRESS_MESSAGE_PATTERN, e.g. "5000 records inserted", are progress messages. Other messages, even if they start with a number, are ignored.
+//
+// The handler applies to all the batches sent afterwards on this connection. It must be set before Execute or Query is called, as Execute only returns when the batch has terminated.
+// Pass nil to remove the handler.
+//
+func (conn *Connection) SetProgressHandler(handler func(rowsSoFar int64)) {
+
+	conn.progressHandler = handler
+}
+
+// Severity tells if a Message is an informative message or PRINT output.
+//
+// The communication protocol carries no severity level like info or warning. PRINT output and informative messages are just two different response types, and Severity records which one the message comes from.
+// Errors are not messages, they are returned as *BatchError.
+//
+type Severity uint8
+
+const (
+	SEVERITY_INFO  Severity = iota + 1 // informative message sent by the server, e.g. progress of BULK INSERT
+	SEVERITY_PRINT                     // output of a PRINT statement
+)
+
+func (sev Severity) String() string {
+
+	switch sev {
+	case SEVERITY_INFO:
+		return "INFO"
+	case SEVERITY_PRINT:
+		return "PRINT"
+	default:
+		return fmt.Sprintf("Severity(%d)", uint8(sev))
+	}
+}
+
+// Message is a PRINT output or an informative message sent by the server during batch execution. It is passed to the handler set by SetMessageHandler.
+//
+// The text is sent by the server as is, without any severity prefix, and the driver doesn't parse it.
+//
+type Message struct {
+	Severity Severity
+	Text     string
+}
+
+// SetMessageHandler sets a function which is called for each PRINT output and informative message sent by the server.
+// Without handler, PRINT output is discarded, and informative messages are only available from Batch.Warnings.
+//
+// The handler is called from the goroutine reading the batch (Query, Execute, Next, Finalize). For a progress message, it is called in addition to the progress handler.
+// As for SetProgressHandler, it must be set before Execute or Query is called.
+// Pass nil to remove the handler.
+//
+func (conn *Connection) SetMessageHandler(handler func(msg Message)) {
+
+	conn.messageHandler = handler
+}
+
+// SetTimeLocation sets the location of the DATE and DATETIME values returned by Scan, ScanStruct, ColValue, SliceScan, MapScan, etc, for all the batches of the connection.
+// It makes timezone handling consistent across a codebase, instead of relying on each call site to pick ColDatetime, ColDatetimeUTC or ColDatetimeIn.
+//
+// As for ColDatetimeIn, the year, month, day, hour, minute, second and nanosecond of the values are kept, only the location changes.
+// TIME values are not affected, they remain in UTC, on January 1, 1900.
+//
+// If loc is nil, which is the default, time.Local is used, as by ColDatetime. Pass time.UTC to get the same values as ColDatetimeUTC.
+// Accessors with an explicit location, like ColDatetime or ColDatetimeUTC, are not affected.
+//
+func (conn *Connection) SetTimeLocation(loc *time.Location) {
+
+	conn.timeLocation = loc
+}
+
+// SetSlowQueryThreshold sets the duration above which a batch is considered as slow, and is passed to the handler set by SetSlowQueryHandler.
+// If d <= 0, slow query detection is disabled, which is the default.
+//
+func (conn *Connection) SetSlowQueryThreshold(d time.Duration) {
+
+	conn.slowQueryThreshold = d
+}
+
+// SetSlowQueryHandler sets a function which is called when a batch sent by Query or Execute takes longer than the threshold set by SetSlowQueryThreshold.
+// The handler receives the SQL text of the batch and its elapsed time, e.g. to log it.
+//
+// The elapsed time is measured from the sending of the batch to the reception of its end. For a batch created by Query, it includes the time spent by the caller to process the records.
+// The handler is called from the goroutine reading the batch, when the end of the batch is received. It is not called for a batch which is discarded or fails because of a communication error.
+//
+// Pass nil to remove the handler.
+//
+func (conn *Connection) SetSlowQueryHandler(handler func(text string, elapsed time.Duration)) {
+
+	conn.slowQueryHandler = handler
+}
+
+// notifySlowQuery calls the slow query handler of the connection, if the batch has run longer than the threshold.
+//
+func (b *Batch) notifySlowQuery() {
+
+	if b.conn.slowQueryHandler == nil || b.conn.slowQueryThreshold <= 0 {
+		return
+	}
+
+	if elapsed := time.Since(b.startTime); elapsed > b.conn.slowQueryThreshold {
+		b.conn.slowQueryHandler(b.text, elapsed)
+	}
+}
+
+// parseProgressMessage returns the record count of a progress message sent by the server, which matches PROGRESS_MESSAGE_PATTERN.
+// If msg is not a progress message, ok is false.
+//
+func parseProgressMessage(msg string) (rowsSoFar int64, ok bool) {
+
+	match := PROGRESS_MESSAGE_PATTERN.FindStringSubmatch(msg)
+	if len(match) < 2 {
+		return 0, false
+	}
+
+	rowsSoFar, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return rowsSoFar, true
+}
+
 // Close closes the connection.
 //
 // To cancel a running query, you can call conn.Close() from another goroutine. The server will notice that the connection has been closed and will free the resources.
 //
 // This function can be called asynchronously from another goroutine, as it is thread safe and can be called multiple times.
+// It can also be called on a nil or partially constructed Connection, whose session has not been created.
 //
 func (conn *Connection) Close() {
 
-	conn.session.Close()
+	if conn == nil {
+		return
+	}
+
+	conn.session.Close() // Session.Close accepts a nil session
 }
 
 // splitConnString splits up the connection string into pairs of attribute and value pairs.
@@ -351,7 +815,7 @@ func splitConnString(s string) (*connStringAttributes, error) {
 		items      []string
 	)
 
-	attributes = &connStringAttributes{}
+	attributes = &connStringAttributes{connectTimeout: -1, keepalive: -1}
 
 	items = strings.Split(s, ";")
 
@@ -381,7 +845,7 @@ func splitConnString(s string) (*connStringAttributes, error) {
 		case "server":
 			attributes.serverAddr = val
 			if strings.Contains(val, ":") == false {
-				attributes.serverAddr = val + ":7777"
+				attributes.serverAddr = val + ":" + strconv.Itoa(SERVER_PORT)
 			}
 		case "login":
 			attributes.login = strings.ToLower(val)
@@ -389,6 +853,35 @@ func splitConnString(s string) (*connStringAttributes, error) {
 			attributes.password = val // original case
 		case "database":
 			attributes.database = strings.ToLower(val)
+		case "connecttimeout":
+			timeout, err := strconv.Atoi(val)
+			if err != nil || timeout < 0 {
+				return nil, fmt.Errorf("Connection string: value for attribute \"%s\" must be a number of seconds >= 0.", attr)
+			}
+			attributes.connectTimeout = timeout
+		case "dialretries":
+			retries, err := strconv.Atoi(val)
+			if err != nil || retries < 0 {
+				return nil, fmt.Errorf("Connection string: value for attribute \"%s\" must be a number >= 0.", attr)
+			}
+			attributes.dialRetries = retries
+		case "keepalive":
+			interval, err := strconv.Atoi(val)
+			if err != nil || interval < 0 {
+				return nil, fmt.Errorf("Connection string: value for attribute \"%s\" must be a number of seconds >= 0.", attr)
+			}
+			attributes.keepalive = interval
+		case "tls":
+			tls, err := strconv.ParseBool(val)
+			if err != nil {
+				return nil, fmt.Errorf("Connection string: value for attribute \"%s\" must be true or false.", attr)
+			}
+			if tls {
+				return nil, fmt.Errorf("Connection string: TLS is not supported by RSQL server.")
+			}
+			attributes.tls = tls
+		case "appname":
+			attributes.appName = val // original case
 		default:
 			return nil, fmt.Errorf("Connection string attribute \"%s\" is not supported.", attr)
 		}
@@ -403,7 +896,7 @@ func splitConnString(s string) (*connStringAttributes, error) {
 //
 // The SQL text should contain at least one SELECT statement. Else, it will simply execute the whole batch, like the Execute method.
 //
-// If the batch contains PRINT statements or sends informative messages (e.g. BULK INSERT periodically sends the number of records inserted so far), they are just ignored.
+// If the batch contains PRINT statements or sends informative messages (e.g. BULK INSERT periodically sends the number of records inserted so far), they are not returned as records. Progress messages are passed to the handler set with SetProgressHandler, and all messages to the handler set with SetMessageHandler.
 //
 // The Query method returns as soon as the first recordset is available.
 //
@@ -427,6 +920,16 @@ func (conn *Connection) Query(text string) (*Batch, error) {
 	}
 	b.conn = conn
 
+	if err := b.conn.contextError(); err != nil {
+		b.err = err
+		return nil, b.err
+	}
+
+	if b.conn.isDead {
+		b.err = fmt.Errorf("Batch: connection has been closed.")
+		return nil, b.err
+	}
+
 	if b.conn.isDirty {
 		b.err = fmt.Errorf("Batch: connection still contains data from previous batch.")
 		return nil, b.err
@@ -438,9 +941,11 @@ func (conn *Connection) Query(text string) (*Batch, error) {
 	// send batch
 
 	session = b.conn.session
+	b.startTime = time.Now()
 
 	if err := session.Send_batch([]byte(b.text)); err != nil {
 		b.err = err
+		b.checkContext()
 		return nil, b.err
 	}
 
@@ -448,7 +953,7 @@ func (conn *Connection) Query(text string) (*Batch, error) {
 
 	// receive messages from server and stop at first recordset
 
-	_ = b.step(sTEP_NEXT_RECORD)
+	_, _ = b.lockedStep(sTEP_NEXT_RECORD)
 
 	return b, nil
 }
@@ -458,7 +963,7 @@ func (conn *Connection) Query(text string) (*Batch, error) {
 // The SQL text of the batch can contain many SQL statements of any kind (INSERT, UPDATE, etc), but there should be no SELECT statement.
 // If SELECT statements are encountered, they are executed but the records returned by the server are just discarded.
 //
-// If the batch contains PRINT statements or sends informative messages (e.g. BULK INSERT periodically sends the number of records inserted so far), they are just ignored.
+// If the batch contains PRINT statements or sends informative messages (e.g. BULK INSERT periodically sends the number of records inserted so far), they are not returned as records. Progress messages are passed to the handler set with SetProgressHandler, and all messages to the handler set with SetMessageHandler.
 //
 // The Execute method returns only when the batch is finished.
 //
@@ -482,6 +987,16 @@ func (conn *Connection) Execute(text string) (*Batch, error) {
 	}
 	b.conn = conn
 
+	if err := b.conn.contextError(); err != nil {
+		b.err = err
+		return nil, b.err
+	}
+
+	if b.conn.isDead {
+		b.err = fmt.Errorf("Batch: connection has been closed.")
+		return nil, b.err
+	}
+
 	if b.conn.isDirty {
 		b.err = fmt.Errorf("Batch: connection still contains data from previous batch.")
 		return nil, b.err
@@ -493,9 +1008,11 @@ func (conn *Connection) Execute(text string) (*Batch, error) {
 	// send batch
 
 	session = b.conn.session
+	b.startTime = time.Now()
 
 	if err := session.Send_batch([]byte(b.text)); err != nil {
 		b.err = err
+		b.checkContext()
 		return nil, b.err
 	}
 
@@ -508,31 +1025,436 @@ func (conn *Connection) Execute(text string) (*Batch, error) {
 	return b, b.err
 }
 
-// String returns the SQL text sent to the server.
+// Exec sends the SQL text on connection conn to the server, like Execute, and returns the record count of the last INSERT, UPDATE, DELETE, etc statement of the batch.
+// It is convenient for simple DML batches, when only the number of records changed is needed.
 //
-func (b *Batch) String() string {
+// If the server has sent no record count, e.g. because of SET NOCOUNT ON, or because the batch contains no DML statement, -1 is returned.
+//
+// The returned error can be *BatchError. If an error is returned, you should close the connection.
+//
+func (conn *Connection) Exec(text string) (int64, error) {
 
-	return b.text
+	b, err := conn.Execute(text)
+	if err != nil {
+		return 0, err
+	}
+
+	if b.execCountFound == false {
+		return -1, nil
+	}
+
+	return b.execRecordCount, nil
 }
 
-// Columns return the column name list of current recordset.
+// Use changes the current database of the connection, by sending the batch "USE [database]" to the server.
+// It avoids closing the connection and logging in again to work in another database.
 //
-func (b *Batch) Columns() ([]string, error) {
+// database is quoted as by BindIdentifier, and must satisfy the same rules. Else, an error is returned and nothing is sent.
+// If the batch succeeds, SessionInfo returns the new database, in lower case. ConnectionString still returns the original connection string.
+//
+// The connection must be available for a new batch, as for Execute. The returned error can be *BatchError, e.g. if the database doesn't exist.
+//
+func (conn *Connection) Use(database string) error {
+	var (
+		err  error
+		text string
+	)
 
-	if !(b.status == sTATUS_RECORD_LAYOUT_AVAILABLE || b.status == sTATUS_RECORD_AVAILABLE) {
-		return nil, fmt.Errorf("Column list not available, no recordset found.") // no need to put error in b.err
+	if text, err = NewSQLpart("USE {{database}}").BindIdentifier("database", database).Text(); err != nil {
+		return fmt.Errorf("Use: %s", err)
 	}
 
-	return b.colnameList, nil
+	if _, err = conn.Execute(text); err != nil {
+		return err
+	}
+
+	conn.database = strings.ToLower(database)
+	conn.databaseChanged = true
+
+	return nil
 }
 
-// RecordCount returns the record count of the last SELECT statement that has terminated.
+// RoundTrip sends the batch "SELECT 1" to the server, and returns the time elapsed until the batch has terminated.
+// It is a cheap way to check that the connection is alive and to measure the latency of the server, e.g. for monitoring.
+//
+// The keepalive messages sent periodically by the driver cannot be used for this, as the server doesn't acknowledge them.
+//
+// The connection must be available for a new batch, as for Execute. If an error is returned, you should close the connection.
+//
+func (conn *Connection) RoundTrip() (time.Duration, error) {
+
+	start := time.Now()
+
+	if _, err := conn.Execute("SELECT 1"); err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
+// ExecuteReturningInt64 sends the SQL text on connection conn to the server, like Execute, and returns the integer value returned by the last SELECT statement of the batch.
+//
+// The batch must end with a SELECT statement returning exactly one record with one column, e.g.:
+//
+//	SET NOCOUNT ON
+//	INSERT INTO mydb..orders (customerid, orderdate, total) VALUES (123, '20161204', 127.50);
+//	SELECT SCOPE_IDENTITY();
+//
+// The column can be of type BIT, TINYINT, SMALLINT, INT, BIGINT, or MONEY and NUMERIC with no fractional part (SCOPE_IDENTITY() returns a NUMERIC).
+//
+// Records of other SELECT statements in the batch are discarded, whatever their number of columns and records.
+//
+// The returned error can be *BatchError. If an error is returned, you should close the connection.
+//
+func (conn *Connection) ExecuteReturningInt64(text string) (int64, error) {
+	var (
+		err            error
+		b              *Batch
+		val            int64
+		valErr         error // conversion error of the value of the current recordset
+		recordsetFound bool
+		colCount       int
+		recordCount    int
+	)
+
+	if b, err = conn.Query(text); err != nil {
+		return 0, err
+	}
+
+	for b.ExistsNextRecordset() { // only the last recordset is checked, but it is known only when there is no next one
+		recordsetFound = true
+		colCount = b.ColCount()
+		recordCount = 0
+		val, valErr = 0, nil
+
+		for b.Next() {
+			if recordCount == 0 && colCount == 1 {
+				val, valErr = b.colScalarInt64(0)
+			}
+			recordCount++
+		}
+
+		if b.Err() != nil {
+			return 0, b.Err()
+		}
+	}
+
+	if err = b.Finalize(); err != nil {
+		return 0, err
+	}
+
+	if recordsetFound == false || recordCount == 0 {
+		return 0, fmt.Errorf("ExecuteReturningInt64: batch must end with a SELECT statement returning one record.")
+	}
+
+	if colCount != 1 {
+		return 0, fmt.Errorf("ExecuteReturningInt64: last recordset must contain exactly one column.")
+	}
+
+	if recordCount > 1 {
+		return 0, fmt.Errorf("ExecuteReturningInt64: last recordset must contain exactly one record.")
+	}
+
+	if valErr != nil {
+		return 0, valErr
+	}
+
+	return val, nil
+}
+
+const WARNINGS_MAX = 100 // maximum number of informative messages kept by a batch, returned by Batch.Warnings
+
+// ErrNoRows is returned by QueryScalar when the last SELECT statement of the batch returns no record.
+//
+var ErrNoRows = errors.New("no record in result set.")
+
+// ErrConcurrentUse is returned by NextErr and Finalize if they are called on a batch while another goroutine is reading it.
+// The offending call reads nothing, so that the stream is not corrupted. The error is only reported to this call, and Err is not affected, as the other goroutine still reads the batch correctly.
+var ErrConcurrentUse = errors.New("Batch: concurrent use by multiple goroutines, records must be read by one goroutine at a time.")
+
+// QueryScalar sends the SQL text on connection conn to the server, like Execute, and returns the value returned by the last SELECT statement of the batch, e.g. for a lookup:
+//
+//	SELECT name FROM mydb..customers WHERE customerid = 123;
+//
+// The value is the natural Go value of the column, as returned by ColValue. If the column is NULL, val is nil and isnull is true.
+//
+// The last SELECT statement must return one column. If it returns no record, or if the batch contains no SELECT statement, ErrNoRows is returned. If it returns more than one record, an error is returned.
+// Records of other SELECT statements in the batch are discarded, whatever their number of columns and records.
+//
+// It is the generic counterpart of ExecuteReturningInt64.
+//
+// The returned error can be *BatchError. If an error is returned, except ErrNoRows, you should close the connection.
+//
+func (conn *Connection) QueryScalar(text string) (val interface{}, isnull bool, err error) {
+	var (
+		b              *Batch
+		recordsetFound bool
+		colCount       int
+		recordCount    int
+	)
+
+	if b, err = conn.Query(text); err != nil {
+		return nil, false, err
+	}
+
+	for b.ExistsNextRecordset() { // only the last recordset is checked, but it is known only when there is no next one
+		recordsetFound = true
+		colCount = b.ColCount()
+		recordCount = 0
+		val, isnull = nil, false
+
+		for b.Next() {
+			if recordCount == 0 && colCount == 1 {
+				val, isnull = b.ColValue(0)
+			}
+			recordCount++
+		}
+
+		if b.Err() != nil {
+			return nil, false, b.Err()
+		}
+	}
+
+	if err = b.Finalize(); err != nil {
+		return nil, false, err
+	}
+
+	if recordsetFound == false {
+		return nil, false, ErrNoRows
+	}
+
+	if colCount != 1 {
+		return nil, false, fmt.Errorf("QueryScalar: last recordset must contain exactly one column.")
+	}
+
+	switch {
+	case recordCount == 0:
+		return nil, false, ErrNoRows
+	case recordCount > 1:
+		return nil, false, fmt.Errorf("QueryScalar: last recordset must contain at most one record.")
+	}
+
+	return val, isnull, nil
+}
+
+// QueryInto sends the SQL text on connection conn to the server, and returns the records of the first recordset, each one scanned into a new T.
+// The batch is finalized, and the records of the other recordsets are discarded.
+//
+//	type Order struct {
+//		ID    int     `rsql:"orderid"`
+//		Total float64 `rsql:"total"`
+//	}
+//
+//	orders, err := drv.QueryInto[Order](conn, "SELECT orderid, total FROM mydb..orders;")
+//
+// If T is a struct, other than time.Time, the records are scanned with ScanStruct. Else, the recordset must contain one column, which is scanned like ScanCopy does, e.g. QueryInto[string].
+//
+// If there is no record, an empty non-nil slice is returned.
+//
+// The returned error can be *BatchError. If an error is returned, you should close the connection.
+//
+func QueryInto[T any](conn *Connection, text string) ([]T, error) {
+	var (
+		err error
+		b   *Batch
+	)
+
+	res := []T{}
+
+	if b, err = conn.Query(text); err != nil {
+		return nil, err
+	}
+
+	if b.ExistsNextRecordset() {
+		for b.Next() {
+			var item T
+
+			if err = b.ScanCopy(&item); err != nil {
+				_ = b.Finalize()
+				return nil, fmt.Errorf("QueryInto: %s", err)
+			}
+
+			res = append(res, item)
+		}
+	}
+
+	if err = b.Finalize(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// colScalarInt64 returns the value of column i as int64, for integer columns, and MONEY or NUMERIC columns with no fractional part.
+//
+// If the column is NULL or cannot be converted to int64, an error is returned.
+//
+func (b *Batch) colScalarInt64(i int) (int64, error) {
+
+	if b.ColIsNull(i) {
+		return 0, fmt.Errorf("column %d is NULL.", i)
+	}
+
+	switch b.ColDatatype(i) {
+	case BIT, TINYINT, SMALLINT, INT, BIGINT:
+		val, _ := b.ColInt64(i)
+		return val, nil
+
+	case MONEY, NUMERIC:
+		s, _ := b.ColNumeric(i)
+
+		if pos := strings.IndexByte(s, '.'); pos != -1 { // fractional part must be 0
+			if strings.Trim(s[pos+1:], "0") != "" {
+				return 0, fmt.Errorf("column %d: value %s is not an integer.", i, s)
+			}
+			s = s[:pos]
+		}
+
+		val, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("column %d: value %s cannot be converted to int64.", i, s)
+		}
+
+		return val, nil
+
+	default:
+		return 0, fmt.Errorf("column %d is not an integer, money or numeric datatype.", i)
+	}
+}
+
+// String returns the SQL text sent to the server.
+//
+func (b *Batch) String() string {
+
+	return b.text
+}
+
+// Text returns the SQL text sent to the server. It is the same as String.
+//
+func (b *Batch) Text() string {
+
+	return b.text
+}
+
+// Columns return the column name list of current recordset.
+// The position of a name in the list is the ordinal of the column, which is the index i passed to ColString, ColInt64, etc.
+//
+// It is available as soon as the recordset is detected, before the first call to Next.
+// When the recordset is finished, the column names are kept and are still available, until the next recordset arrives.
+//
+func (b *Batch) Columns() ([]string, error) {
+
+	if b.colnameList == nil {
+		return nil, fmt.Errorf("Column list not available, no recordset found.") // no need to put error in b.err
+	}
+
+	return b.colnameList, nil
+}
+
+// ColumnIndex returns the ordinal of the column with the specified name, in the current or just finished recordset.
+//
+// ok is false if no column has this name, or if the name is ambiguous because many columns have the same name.
+//
+func (b *Batch) ColumnIndex(name string) (i int, ok bool) {
+
+	i, ok = b.colnameMap[name]
+
+	return i, ok
+}
+
+// ColumnNameInfo describes a column name of a recordset, as returned by Batch.ColumnNameInfo.
+//
+type ColumnNameInfo struct {
+	Index     int   // ordinal of the column, as returned by ColumnIndex. -1 if the name is ambiguous.
+	Ambiguous bool  // true if many columns have this name. ColumnIndex and ScanStruct cannot use it.
+	Indexes   []int // ordinals of all the columns having this name, in increasing order
+}
+
+// ColumnNameInfo returns information about each column name of the current or just finished recordset, to find out which names can be used with ColumnIndex.
+//
+// E.g. for SELECT a.id, b.id, a.name FROM ..., the "id" entry is {Index: -1, Ambiguous: true, Indexes: [0 1]}, and the "name" entry is {Index: 2, Indexes: [2]}.
+//
+// Columns without name are not in the map. If no recordset is available, an empty map is returned.
+//
+func (b *Batch) ColumnNameInfo() map[string]ColumnNameInfo {
+
+	res := make(map[string]ColumnNameInfo, len(b.colnameList))
+
+	for i, name := range b.colnameList {
+		if name == "" {
+			continue
+		}
+
+		info, ok := res[name]
+		if ok {
+			info.Index = -1
+			info.Ambiguous = true
+		} else {
+			info.Index = i
+		}
+		info.Indexes = append(info.Indexes, i)
+
+		res[name] = info
+	}
+
+	return res
+}
+
+// ColName returns the name of column i, in the current or just finished recordset.
+//
+// It returns an empty string if i is out of range, if no recordset is available, or if the column has no name.
+//
+func (b *Batch) ColName(i int) string {
+
+	if i < 0 || i >= len(b.colnameList) {
+		return ""
+	}
+
+	return b.colnameList[i]
+}
+
+// RecordCount returns the record count of the last SELECT statement that has terminated.
 //
 func (b *Batch) RecordCount() int64 {
 
 	return b.recordCount
 }
 
+// RecordsetCount returns the number of recordsets received so far from the server. After the batch has terminated, it is the total number of recordsets of the batch.
+//
+// A recordset is counted as soon as it is detected, even if it contains no record.
+//
+func (b *Batch) RecordsetCount() int {
+
+	return b.recordsetCount
+}
+
+// ExpectRecordsets returns an error if the batch has not returned exactly k recordsets.
+// It must be called after the batch has terminated, e.g. after Finalize, to detect a conditional SELECT statement that has not been executed.
+//
+//	if err = b.Finalize(); err != nil {
+//		log.Fatalf("%s", err)
+//	}
+//
+//	if err = b.ExpectRecordsets(3); err != nil {
+//		log.Fatalf("%s", err)
+//	}
+//
+// If the batch has not terminated, an error is returned.
+//
+func (b *Batch) ExpectRecordsets(k int) error {
+
+	if b.status != sTATUS_BATCH_END {
+		return fmt.Errorf("ExpectRecordsets: batch has not terminated.")
+	}
+
+	if b.recordsetCount != k {
+		return fmt.Errorf("ExpectRecordsets: batch has returned %d recordset(s), %d expected.", b.recordsetCount, k)
+	}
+
+	return nil
+}
+
 // ExecRecordCount returns the record count of the last INSERT, UPDATE, DELETE, etc statement that has terminated.
 //
 // If SET NOCOUNT is ON, this information is not available.
@@ -542,6 +1464,17 @@ func (b *Batch) ExecRecordCount() int64 {
 	return b.execRecordCount
 }
 
+// TotalRowsAffected returns the sum of the record counts of all INSERT, UPDATE, DELETE, etc statements that have terminated so far in the batch.
+//
+// The record count of a statement executed while SET NOCOUNT is ON is not sent by the server, and is not included in the total.
+// So, if NOCOUNT is ON for some statements of the batch, the total is lower than the real number of affected records.
+// If NOCOUNT is ON for the whole batch, it returns 0.
+//
+func (b *Batch) TotalRowsAffected() int64 {
+
+	return b.execCountTotal
+}
+
 // Err returns an error that occurred during batch execution.
 // The returned error can be caused by a network problem.
 // But usually, the error is a *BatchError, which is generated during batch execution (syntax error, division by 0, duplicate in unique index, etc).
@@ -555,6 +1488,43 @@ func (b *Batch) Err() error {
 	return b.err
 }
 
+// Warnings returns the texts of the informative messages sent by the server during the batch, in the order received, so that they can be checked after the batch, e.g. for data-quality checks during imports.
+//
+// The communication protocol has no dedicated response type nor severity for warnings: the server sends all non-fatal messages as informative messages, which include the progress messages of BULK INSERT.
+// So, the driver cannot tell a warning from other informative messages, and all of them are returned. Conditions which abort a statement, like a conversion or a truncation error, are not warnings but errors, returned by Err.
+// PRINT output is not included, see SetMessageHandler.
+//
+// At most WARNINGS_MAX messages are kept, as a long BULK INSERT can send many progress messages.
+//
+func (b *Batch) Warnings() []string {
+
+	return b.warnings
+}
+
+// IsConnectionDead returns true if the connection cannot be used any more because of the error of the batch.
+// It is the case if b.Err() is a network error, or a *BatchError with State 127 (the server has closed the connection), or if the batch has been discarded.
+//
+// It is useful for connection pools, to decide with a single call if the connection must be closed.
+// Note that if it returns false, the connection can only be reused if it is usable (see Connection.IsUsable), that is, if the batch has terminated.
+//
+func (b *Batch) IsConnectionDead() bool {
+
+	if b.conn.isDead {
+		return true
+	}
+
+	switch err := b.err.(type) {
+	case nil:
+		return false
+	case *BatchError:
+		return err.State == 127
+	case net.Error:
+		return true
+	default:
+		return err == io.EOF || err == io.ErrUnexpectedEOF
+	}
+}
+
 // Rc returns the return code of the batch, after it has terminated.
 //
 func (b *Batch) Rc() int64 {
@@ -571,15 +1541,48 @@ const (
 )
 
 // Next reads all messages sent from the server, until a record is reached.
-// If the batch contains PRINT statements or sends informative messages (e.g. BULK INSERT periodically sends the number of records inserted so far), they are just ignored.
+// If the batch contains PRINT statements or sends informative messages (e.g. BULK INSERT periodically sends the number of records inserted so far), they are not returned as records. Progress messages are passed to the handler set with SetProgressHandler, and all messages to the handler set with SetMessageHandler.
 //
 // If no more record is available, or if an error occurred, Next returns false.
 //
 // After Next returned false, you must call the batch Err() method to check if an error occurred.
 //
+// A batch must be read by one goroutine at a time. If Next is called while another goroutine is reading the batch, it reads nothing and returns false. NextErr returns ErrConcurrentUse in this case.
+//
 func (b *Batch) Next() bool {
 
-	return b.step(sTEP_NEXT_RECORD)
+	ok, _ := b.lockedStep(sTEP_NEXT_RECORD)
+
+	return ok
+}
+
+// NextErr is the same as Next, but also returns the error, so that it cannot be forgotten.
+// It returns true and nil if a record is available, false and nil if no more record is available in the recordset, and false and the error if an error occurred.
+//
+//	for {
+//		ok, err := b.NextErr()
+//		if err != nil {
+//			log.Fatalf("%s", err)
+//		}
+//		if !ok {
+//			break
+//		}
+//
+//		... process record
+//	}
+//
+func (b *Batch) NextErr() (bool, error) {
+
+	ok, err := b.lockedStep(sTEP_NEXT_RECORD)
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		return true, nil
+	}
+
+	return false, b.Err()
 }
 
 // ExistsNextRecordset checks if a recordset is available.
@@ -602,50 +1605,252 @@ func (b *Batch) Next() bool {
 //		SELECT childId, parentId, fname, lname, birthdate FROM mydb..children WHERE parentId = 1234;
 //	`
 //
-//	if b, err = conn.Query(text); err != nil { // send batch
-//		log.Fatalf("%s", err)
-//	}
+//	if b, err = conn.Query(text); err != nil { // send batch
+//		log.Fatalf("%s", err)
+//	}
+//
+//	//========= get first recordset
+//
+//	for b.Next() {
+//		... process record
+//	}
+//
+//	if b.Err() != nil {
+//		log.Fatalf("%s", b.Err())
+//	}
+//
+//	fmt.Printf("(%d row(s) affected)\n", b.RecordCount())
+//
+//	//========= get second recordset
+//
+//	if b.ExistsNextRecordset() == false {
+//		log.Fatal("no second recorset")
+//	}
+//
+//	for b.Next() {
+//		... process record
+//	}
+//
+//	if b.Err() != nil {
+//		log.Fatalf("%s", b.Err())
+//	}
+//
+//	fmt.Printf("(%d row(s) affected)\n", b.RecordCount())
+//
+//	//========= gracefully terminate the batch
+//
+//	if err := b.Finalize(); err != nil { // calling Finalize is not necessary if you have read all records
+//		log.Fatalf("%s", err)
+//	}
+//
+func (b *Batch) ExistsNextRecordset() bool {
+
+	return b.status == sTATUS_RECORD_LAYOUT_AVAILABLE
+}
+
+// Event is the type of the next event of a batch, as returned by PeekNext.
+type Event uint8
+
+const (
+	EVENT_RECORDSET     Event = iota + 1 // a new recordset is available. Next will read its first record, or return false if it is empty.
+	EVENT_RECORD                         // a record is available in the current recordset. Next will return true.
+	EVENT_RECORDSET_END                  // no more record in the current recordset. Next will return false.
+	EVENT_ERROR                          // an error has occurred, or will be read next. Next will return false, and Err will return the error.
+	EVENT_BATCH_END                      // the batch has terminated. Next will return false.
+)
+
+func (ev Event) String() string {
+
+	switch ev {
+	case EVENT_RECORDSET:
+		return "RECORDSET"
+	case EVENT_RECORD:
+		return "RECORD"
+	case EVENT_RECORDSET_END:
+		return "RECORDSET_END"
+	case EVENT_ERROR:
+		return "ERROR"
+	case EVENT_BATCH_END:
+		return "BATCH_END"
+	default:
+		return fmt.Sprintf("Event(%d)", uint8(ev))
+	}
+}
+
+// PeekNext returns the next event of the batch, without consuming it.
+// It allows generic consumers to decide what to do, instead of relying on Next returning false and ExistsNextRecordset.
+//
+// The current record, if any, is not modified and can still be read.
+//
+// PeekNext may block until the server sends the next response. PRINT statements and informative messages sent before the next event are read and ignored, as Next would do.
+//
+// If a communication error occurs, it is put in b.Err() and EVENT_ERROR is returned.
+// If another goroutine is reading the batch, PeekNext reads nothing and returns EVENT_ERROR, but b.Err() is not affected.
+//
+func (b *Batch) PeekNext() Event {
+	var (
+		err  error
+		resp rsqlib.Response_t
+	)
+
+	if b.conn.session.Acquire_reader() != nil { // another goroutine is reading the batch, b.status and b.err must not be read
+		return EVENT_ERROR
+	}
+	defer b.conn.session.Release_reader()
+	defer b.checkContext()
+
+	if b.status == sTATUS_BATCH_END {
+		if b.err != nil {
+			return EVENT_ERROR
+		}
+		return EVENT_BATCH_END
+	}
+
+	if b.err != nil {
+		return EVENT_ERROR
+	}
+
+	if b.status == sTATUS_RECORD_LAYOUT_AVAILABLE {
+		return EVENT_RECORDSET
+	}
+
+	for {
+		if resp, err = b.conn.session.Peek_response_type(); err != nil {
+			b.err = err
+			return EVENT_ERROR
+		}
+
+		switch resp {
+		case rsqlib.RESTYP_RECORD_LAYOUT:
+			return EVENT_RECORDSET
+
+		case rsqlib.RESTYP_RECORD:
+			return EVENT_RECORD
+
+		case rsqlib.RESTYP_RECORD_FINISHED:
+			return EVENT_RECORDSET_END
+
+		case rsqlib.RESTYP_ERROR:
+			return EVENT_ERROR
+
+		case rsqlib.RESTYP_BATCH_END:
+			return EVENT_BATCH_END
+
+		case rsqlib.RESTYP_EXECUTION_FINISHED, rsqlib.RESTYP_PRINT, rsqlib.RESTYP_MESSAGE:
+			if _, err = b.conn.session.Read_response_type(); err != nil { // consume response type
+				b.err = err
+				return EVENT_ERROR
+			}
+
+			if err = b.readInformativeMessage(resp); err != nil {
+				b.err = err
+				return EVENT_ERROR
+			}
+
+		default:
+			b.err = b.unexpectedResponseError(resp)
+			return EVENT_ERROR
+		}
+	}
+}
+
+// Recordset is passed to the callback of ForEachRecordset, for each recordset of the batch.
+//
+// It embeds the Batch, so that records are read with rs.Next(), and column values with rs.ColString(i), rs.Scan(...), etc.
+// The callback must not call Finalize, Discard or Close.
 //
-//	//========= get first recordset
+type Recordset struct {
+	*Batch
+
+	index int
+}
+
+// Index returns the position of the recordset in the batch, starting at 0.
 //
-//	for b.Next() {
-//		... process record
-//	}
+func (rs *Recordset) Index() int {
+
+	return rs.index
+}
+
+// ForEachRecordset reads the whole batch, and calls fn once for each recordset, in order.
+// It replaces the loop on Next and ExistsNextRecordset, followed by Finalize. For example:
 //
-//	if b.Err() != nil {
-//		log.Fatalf("%s", b.Err())
+//	if b, err = conn.Query(text); err != nil {
+//		log.Fatalf("%s", err)
 //	}
 //
-//	fmt.Printf("(%d row(s) affected)\n", b.RecordCount())
-//
-//	//========= get second recordset
+//	err = b.ForEachRecordset(func(rs *drv.Recordset) error {
+//		columns, _ := rs.Columns()
+//		fmt.Println(rs.Index(), columns)
 //
-//	if b.ExistsNextRecordset() == false {
-//		log.Fatal("no second recorset")
-//	}
+//		for rs.Next() {
+//			... process record
+//		}
+//		return nil
+//	})
 //
-//	for b.Next() {
-//		... process record
-//	}
+// fn doesn't need to read all records. The remaining records are skipped after fn returns.
 //
-//	if b.Err() != nil {
-//		log.Fatalf("%s", b.Err())
-//	}
+// If fn returns an error, the remaining statements of the batch are executed as by Finalize, and this error is returned.
+// If an error occurs during batch execution, iteration stops and this error is returned.
 //
-//	fmt.Printf("(%d row(s) affected)\n", b.RecordCount())
+// ForEachRecordset must be called on a batch created by Query, before Next has been called.
 //
-//	//========= gracefully terminate the batch
+func (b *Batch) ForEachRecordset(fn func(rs *Recordset) error) error {
+
+	if b.err != nil {
+		return b.err
+	}
+
+	if !(b.status == sTATUS_RECORD_LAYOUT_AVAILABLE || b.status == sTATUS_BATCH_END) {
+		return fmt.Errorf("ForEachRecordset: must be called before Next.")
+	}
+
+	for index := 0; b.ExistsNextRecordset(); index++ {
+		if err := fn(&Recordset{Batch: b, index: index}); err != nil {
+			_ = b.Finalize()
+			return err
+		}
+
+		for b.Next() { // skip remaining records, until next recordset or end of batch
+		}
+
+		if b.err != nil {
+			return b.err
+		}
+	}
+
+	return b.err
+}
+
+// lockedStep calls step while holding the reader of the session, so that b.err and b.status are not accessed by two goroutines at the same time.
+// If another goroutine is reading the batch, it reads nothing and returns false and ErrConcurrentUse. b.err is not set, as it belongs to the reading goroutine.
 //
-//	if err := b.Finalize(); err != nil { // calling Finalize is not necessary if you have read all records
-//		log.Fatalf("%s", err)
-//	}
+func (b *Batch) lockedStep(option stepOption) (bool, error) {
+
+	if b.conn.session.Acquire_reader() != nil {
+		return false, ErrConcurrentUse
+	}
+	defer b.conn.session.Release_reader()
+
+	return b.step(option), nil
+}
+
+// checkContext replaces b.err by an error wrapping the context error, if the context of the connection is done, as b.err is then caused by the closing of the session.
 //
-func (b *Batch) ExistsNextRecordset() bool {
+func (b *Batch) checkContext() {
 
-	return b.status == sTATUS_RECORD_LAYOUT_AVAILABLE
+	if b.err == nil {
+		return
+	}
+
+	if err := b.conn.contextError(); err != nil {
+		b.err = err
+	}
 }
 
 // step reads all the response message sent by the server.
+// The caller must hold the reader of the session, see lockedStep.
 //
 // It returns when a recordset is reached (for batch sent by conn.Query), or executes all or remaining statements until the batch terminates (for batch sent by conn.Execute).
 //
@@ -669,6 +1874,8 @@ func (b *Batch) step(option stepOption) bool {
 
 	session = b.conn.session
 
+	defer b.checkContext()
+
 	//=== read response ===
 
 	for {
@@ -688,29 +1895,20 @@ func (b *Batch) step(option stepOption) bool {
 				return false
 			}
 
-			b.colnameList = colnameList
-
-			colnameMap := make(map[string]int, len(colnameList)) // create map
-			for i, name := range colnameList {
-				if name == "" {
-					continue
-				}
-
-				if _, ok := colnameMap[name]; ok == true {
-					colnameMap[name] = i
-				} else {
-					delete(colnameMap, name) // ambiguous column name
-				}
+			if equalStringSlices(colnameList, b.colnameList) == false || b.colnameMap == nil { // if same column names as previous recordset, keep the map
+				b.colnameMap = newColnameMap(colnameList)
 			}
 
-			b.colnameMap = colnameMap
+			b.colnameList = colnameList
+
+			// create record, reusing the fields of the previous recordset if it had the same layout
 
-			// create record
-			if record, err = session.Create_row(); err != nil {
+			if record, err = session.Create_row_reusing(b.prevRecord); err != nil {
 				b.err = err
 				return false
 			}
 
+			b.prevRecord = nil
 			b.record = record
 
 			b.recordCount = 0
@@ -747,58 +1945,24 @@ func (b *Batch) step(option stepOption) bool {
 			}
 
 			if recordCount != b.recordCount {
-				b.err = fmt.Errorf("Batch: recordcount mismatch (RSQL bug).", recordCount, b.recordCount)
+				b.err = b.recordCountMismatchError(recordCount)
 				return false
 			}
 
-			// discard record
+			// discard record. Column names are kept until the next recordset arrives.
 
-			b.colnameList = nil
-			b.colnameMap = nil
+			b.prevRecord = b.record // kept to be reused by next recordset
 			b.record = nil
 			b.recordCount = recordCount
 
 			b.status = sTATUS_RECORD_END
 
-		case rsqlib.RESTYP_EXECUTION_FINISHED: // if SET NOCOUNT ON, INSERT etc statements don't send this information
-			var execRecordCount int64
-
-			if execRecordCount, err = session.Read_int64(); err != nil {
-				b.err = err
-				return false
-			}
-
-			b.execRecordCount = execRecordCount
-
-		case rsqlib.RESTYP_PRINT:
-			var row []rsqlib.IField
-
-			// create row
-
-			if row, err = session.Create_row(); err != nil {
-				b.err = err
-				return false
-			}
-
-			if err = session.Fill_row_with_values(row); err != nil {
-				b.err = err
-				return false
-			}
-
-			//fmt.Printf("PRINT detected\n") // ignore row
-
-		case rsqlib.RESTYP_MESSAGE:
-			var msg_string string
-
-			if msg_string, err = session.Read_string(); err != nil {
+		case rsqlib.RESTYP_EXECUTION_FINISHED, rsqlib.RESTYP_PRINT, rsqlib.RESTYP_MESSAGE:
+			if err = b.readInformativeMessage(resp); err != nil {
 				b.err = err
 				return false
 			}
 
-			_ = msg_string
-
-			//fmt.Println(msg_string) // ignore message
-
 		case rsqlib.RESTYP_ERROR:
 			var error_info *rsqlib.Error_info
 
@@ -811,6 +1975,10 @@ func (b *Batch) step(option stepOption) bool {
 
 			b.err = be
 
+			if be.State == 127 { // server closes the connection
+				b.conn.isDead = true
+			}
+
 			// the server will send RESTYP_BATCH_END after it has sent this error.
 			// if state == 127 (only THROW or ERROR_SERVER_ABORT can generate it), server also closed the connection.
 
@@ -828,27 +1996,176 @@ func (b *Batch) step(option stepOption) bool {
 
 			b.conn.isDirty = false // connection can be used for another batch
 
+			b.notifySlowQuery()
+
 			return false
 
-		default:
-			panic("impossible")
+		default: // unknown response type, e.g. because server version doesn't match. The following bytes cannot be interpreted, so the connection is useless.
+			b.err = b.unexpectedResponseError(resp)
+			return false
 		}
 	} // end of response loop
 
 }
 
+// unexpectedResponseError closes the connection and returns an error, when the server has sent a response type that the client doesn't know.
+// It can happen if the response type constants of the client and the server don't match, because of a version mismatch.
+// As the content of such a response cannot be read, the remaining data sent by the server cannot be interpreted, and the connection cannot be used any more.
+//
+func (b *Batch) unexpectedResponseError(resp rsqlib.Response_t) error {
+
+	b.conn.isDead = true
+	b.conn.Close()
+
+	return fmt.Errorf("Batch: unexpected response type %d from server, client and server versions may not match. Connection has been closed.", resp)
+}
+
+// recordCountMismatchError closes the connection and returns an error, when the record count sent by the server at the end of a recordset is not the number of records received.
+// It is a bug of the server or of the driver. As records may have been lost or misinterpreted, the remaining data sent by the server cannot be trusted, and the connection cannot be used any more.
+//
+func (b *Batch) recordCountMismatchError(recordCount int64) error {
+
+	b.conn.isDead = true
+	b.conn.Close()
+
+	return fmt.Errorf("Batch: record count mismatch, server sent %d but %d records were received (RSQL bug). Connection has been closed.", recordCount, b.recordCount)
+}
+
+// newColnameMap returns a map of the unique column names of colnameList to their ordinal.
+// Ambiguous names, shared by many columns, and empty names are not in the map.
+//
+func newColnameMap(colnameList []string) map[string]int {
+
+	colnameMap := make(map[string]int, len(colnameList))
+	ambiguousNames := make(map[string]bool)
+
+	for i, name := range colnameList {
+		if name == "" {
+			continue
+		}
+
+		if _, ok := colnameMap[name]; ok == true {
+			ambiguousNames[name] = true
+		} else {
+			colnameMap[name] = i
+		}
+	}
+
+	for name := range ambiguousNames {
+		delete(colnameMap, name) // ambiguous column name
+	}
+
+	return colnameMap
+}
+
+// equalStringSlices returns true if a and b contain the same strings.
+//
+func equalStringSlices(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readInformativeMessage reads the content of a RESTYP_EXECUTION_FINISHED, RESTYP_PRINT or RESTYP_MESSAGE response, whose type has already been read.
+//
+// These messages don't change the status of the batch.
+//
+func (b *Batch) readInformativeMessage(resp rsqlib.Response_t) error {
+	var (
+		err     error
+		session *rsqlib.Session
+	)
+
+	session = b.conn.session
+
+	switch resp {
+	case rsqlib.RESTYP_EXECUTION_FINISHED: // if SET NOCOUNT ON, INSERT etc statements don't send this information
+		var execRecordCount int64
+
+		if execRecordCount, err = session.Read_int64(); err != nil {
+			return err
+		}
+
+		b.execRecordCount = execRecordCount
+		b.execCountFound = true
+		b.execCountTotal += execRecordCount
+
+	case rsqlib.RESTYP_PRINT:
+		var row []rsqlib.IField
+
+		// create row
+
+		if row, err = session.Create_row(); err != nil {
+			return err
+		}
+
+		if err = session.Fill_row_with_values(row); err != nil {
+			return err
+		}
+
+		if b.conn.messageHandler != nil {
+			texts := make([]string, len(row))
+			for i, field := range row {
+				texts[i] = field.String()
+			}
+
+			b.conn.messageHandler(Message{Severity: SEVERITY_PRINT, Text: strings.Join(texts, " ")})
+		}
+
+	case rsqlib.RESTYP_MESSAGE:
+		var msg_string string
+
+		if msg_string, err = session.Read_string(); err != nil {
+			return err
+		}
+
+		if b.conn.progressHandler != nil {
+			if rowsSoFar, ok := parseProgressMessage(msg_string); ok {
+				b.conn.progressHandler(rowsSoFar)
+			}
+		}
+
+		if len(b.warnings) < WARNINGS_MAX {
+			b.warnings = append(b.warnings, msg_string)
+		}
+
+		if b.conn.messageHandler != nil {
+			b.conn.messageHandler(Message{Severity: SEVERITY_INFO, Text: msg_string})
+		}
+
+	default:
+		panic("impossible")
+	}
+
+	return nil
+}
+
 // Finalize executes all remaining statements until end of a Query batch.
 //
 // It is only useful to gracefully terminate a batch created by the Query method. But if you have read all records from a batch, this method is useless and does nothing.
 //
 // If you are reading a record, decide that you don't need to read the remaining records and just want to silently execute the remaining statements, you must call Finalize().
 //
-// Note that if you want to discard the remaining of the batch, you can also just close the connection (but the remaining statements will not be executed, though).
+// Note that if you want to discard the remaining of the batch, you can call Discard, which closes the connection (but the remaining statements will not be executed, though).
 //
 // Finalize does nothing on a batch created by the Execute method.
 //
 func (b *Batch) Finalize() error {
 
+	if b.conn.session.Acquire_reader() != nil { // another goroutine is reading the batch
+		return ErrConcurrentUse
+	}
+	defer b.conn.session.Release_reader()
+
 	if b.err != nil {
 		return b.err
 	}
@@ -860,6 +2177,100 @@ func (b *Batch) Finalize() error {
 	return b.err
 }
 
+// CheckNoLeftover checks that, after the batch has terminated, no unexpected byte sent by the server is pending in the connection buffer.
+//
+// Leftover bytes are a sign of a desynchronization of the communication protocol between the driver and the server, which is unrecoverable. In this case, an error is returned and you should close the connection.
+//
+// This method is useful for debugging. It returns an error if the batch has not terminated yet.
+//
+func (b *Batch) CheckNoLeftover() error {
+
+	if b.status != sTATUS_BATCH_END {
+		return fmt.Errorf("CheckNoLeftover: batch has not terminated.")
+	}
+
+	if b.conn.isDead {
+		return nil
+	}
+
+	if n := b.conn.session.Buffered(); n != 0 {
+		return fmt.Errorf("CheckNoLeftover: %d unexpected bytes pending after end of batch (protocol mismatch).", n)
+	}
+
+	return nil
+}
+
+// Discard abandons the remaining of the batch, without executing the remaining statements.
+//
+// The communication protocol has no request to cancel a running batch. So, Discard closes the connection, and the server will notice it and free the resources.
+// The connection is marked as dead and cannot be used for another batch. You must open a new connection if needed.
+//
+// The difference with Finalize is:
+//
+//    - Finalize executes all remaining statements until the batch terminates, and the connection can be used for another batch.
+//    - Discard gives up the batch. The remaining statements are not executed (if the server has not already executed them), and the connection is closed.
+//
+// If the batch has already terminated, Discard does nothing and the connection can still be used.
+//
+func (b *Batch) Discard() {
+
+	if b.status == sTATUS_BATCH_END {
+		return
+	}
+
+	b.conn.isDead = true
+	b.conn.Close()
+
+	if b.err == nil {
+		b.err = fmt.Errorf("Batch: discarded, connection has been closed.")
+	}
+
+	b.status = sTATUS_BATCH_END
+}
+
+// Close terminates the batch, so that the connection is clean and can be used for another batch.
+// It implements the io.Closer interface.
+//
+// If the batch has not terminated yet (e.g. a batch created by Query which has been partially read), Close executes all remaining statements, like Finalize.
+// If the batch has already terminated, Close does nothing.
+//
+// It returns the error of the batch, if any.
+//
+// Deferring b.Close() just after Query or Execute is the recommended pattern:
+//
+//	if b, err = conn.Query(text); err != nil {
+//		log.Fatalf("%s", err)
+//	}
+//	defer b.Close()
+//
+func (b *Batch) Close() error {
+
+	return b.Finalize()
+}
+
+// LoginError is returned by NewConnection when the server has rejected the login, e.g. because of bad login name or password, or unknown database.
+// Other errors returned by NewConnection are network errors.
+//
+//    var lerr *drv.LoginError
+//    if errors.As(err, &lerr) {
+//        ... authentication failure
+//    }
+//
+type LoginError struct {
+	Message string // reason sent by the server. Usually empty, as the server currently sends no reason.
+}
+
+// Error implements the error interface.
+//
+func (le *LoginError) Error() string {
+
+	if le.Message == "" {
+		return "Connection: login failed."
+	}
+
+	return fmt.Sprintf("Connection: login failed, %s.", le.Message)
+}
+
 // BatchError contains an error that occurred during execution of the batch, such as syntax error, division by 0, overflow, constraint violation, etc.
 //
 // If the error is a *BatchError, the connection can be used to send other batches. But if State is 127, it won't be possible because the server has closed the connection.
@@ -888,6 +2299,102 @@ func (be *BatchError) Error() string {
 	return fmt.Sprintf("%d:%d[%d] %s", be.LineNo, be.LinePos, be.State, be.Text)
 }
 
+// Detail returns a verbose diagnostic of the error, for debugging. It has the same format as rsqlib.Error_info.String_format(level, false):
+//
+//    level 0:    line and position, category, message, severity, state and text
+//    level 1:    same as level 0, prefixed by the source file, function and line in the server where the error was raised
+//    level 2:    same as level 1, followed by the backtrace of the server
+//
+// Error() returns a shorter message.
+//
+func (be *BatchError) Detail(level int) string {
+
+	switch level {
+	case 0:
+		return fmt.Sprintf("%d:%d [%s/%s/%s/%d] <%s>", be.LineNo, be.LinePos, be.Category, be.Message, be.Severity, be.State, be.Text)
+	case 1:
+		return fmt.Sprintf("%s.%s:%d %d:%d [%s/%s/%s/%d] <%s>", be.SrcFile, be.SrcFuncname, be.SrcLineNo, be.LineNo, be.LinePos, be.Category, be.Message, be.Severity, be.State, be.Text)
+	default:
+		return fmt.Sprintf("%s.%s:%d %d:%d [%s/%s/%s/%d] <%s>\n%s", be.SrcFile, be.SrcFuncname, be.SrcLineNo, be.LineNo, be.LinePos, be.Category, be.Message, be.Severity, be.State, be.Text, be.SrcBacktrace)
+	}
+}
+
+// Annotate returns text, which should be the SQL text of the batch, with a line containing a caret under the position LineNo:LinePos where the server reported the error, followed by the error message.
+// Each line is prefixed by its line number, for debugging:
+//
+//       1 | SELECT name
+//       2 | FROM mydb..customers WHER custid = 12
+//         |                           ^ 2:27[1] syntax error
+//
+// LinePos counts characters, not bytes, from 1. If LineNo is not a line of text, the error message is appended at the end.
+//
+func (be *BatchError) Annotate(text string) string {
+	var (
+		buff  strings.Builder
+		found bool
+	)
+
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+
+	for i, line := range lines {
+		fmt.Fprintf(&buff, "%6d | %s\n", i+1, line)
+
+		if int64(i+1) != be.LineNo {
+			continue
+		}
+
+		found = true
+		buff.WriteString("       | ")
+
+		var pos int64 = 1
+		for _, c := range line { // tabs are kept, so that the caret is aligned with the error position
+			if pos >= be.LinePos {
+				break
+			}
+
+			if c == '\t' {
+				buff.WriteByte('\t')
+			} else {
+				buff.WriteByte(' ')
+			}
+			pos++
+		}
+
+		fmt.Fprintf(&buff, "^ %s\n", be.Error())
+	}
+
+	if found == false {
+		fmt.Fprintf(&buff, "       | %s\n", be.Error())
+	}
+
+	return buff.String()
+}
+
+// AnnotateError is the same as be.Annotate(text), where text is the SQL text of sql, which is a *SQLtext or *SQLpart.
+// If err is not a *BatchError, or if the SQL text of sql cannot be rendered, an error is returned.
+//
+//    if _, err = conn.Execute(part.MustText()); err != nil {
+//        if s, err2 := drv.AnnotateError(part, err); err2 == nil {
+//            log.Print(s)
+//        }
+//        log.Fatalf("%s", err)
+//    }
+//
+func AnnotateError(sql interface{ Text() (string, error) }, err error) (string, error) {
+	var be *BatchError
+
+	if errors.As(err, &be) == false {
+		return "", fmt.Errorf("AnnotateError: error is not a *BatchError.")
+	}
+
+	text, err := sql.Text()
+	if err != nil {
+		return "", err
+	}
+
+	return be.Annotate(text), nil
+}
+
 // newBatchError creates a new BatchError by copying information from a rsqlib.Error_info.
 //
 func newBatchError(e *rsqlib.Error_info) *BatchError {
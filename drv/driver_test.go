@@ -0,0 +1,177 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"rsql/internal/fakeserver"
+	"rsql/rsqlib"
+)
+
+// Benchmark_small_queries runs many small batches on the same connection, served by the fake server.
+//
+func Benchmark_small_queries(b *testing.B) {
+
+	script := []fakeserver.Response{
+		fakeserver.Recordset([]fakeserver.Column{{Name: "n", Datatype: rsqlib.DTYPE_INT}, {Name: "s", Datatype: rsqlib.DTYPE_VARCHAR, Precision: 10}}, []interface{}{1, "hello"}),
+		fakeserver.BatchEnd(0),
+	}
+
+	scripts := make([][]fakeserver.Response, b.N)
+	for i := range scripts {
+		scripts[i] = script
+	}
+
+	conn, done := newFakeConnection(b, scripts...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		batch, err := conn.Query("SELECT 1, 'hello'")
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+
+		for batch.Next() {
+		}
+
+		if err = batch.Finalize(); err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+
+	b.StopTimer()
+
+	if err := <-done; err != nil {
+		b.Fatalf("%s", err)
+	}
+}
+
+func Test_use_invalid_name(t *testing.T) {
+
+	conn := &Connection{database: "mydb"} // no session, nothing must be sent
+
+	for _, name := range []string{"", "my\x00db", strings.Repeat("a", IDENTIFIER_LENGTH_MAX+1)} {
+		if err := conn.Use(name); err == nil {
+			t.Fatalf("%q: error expected", name)
+		}
+	}
+
+	if conn.database != "mydb" || conn.databaseChanged {
+		t.Fatalf("database should not change")
+	}
+}
+
+func Test_record_count_mismatch(t *testing.T) {
+
+	b := &Batch{conn: &Connection{}, recordCount: 41}
+
+	err := b.recordCountMismatchError(42)
+
+	if strings.Contains(err.Error(), "42") == false || strings.Contains(err.Error(), "41") == false {
+		t.Fatalf("message should contain both counts: %s", err)
+	}
+
+	if b.conn.isDead == false || b.IsConnectionDead() == false {
+		t.Fatalf("connection should be dead")
+	}
+}
+
+func Test_column_names_duplicates(t *testing.T) {
+
+	colnameList := []string{"id", "id", "name", "", "", "total", "id"}
+
+	b := &Batch{colnameList: colnameList, colnameMap: newColnameMap(colnameList)}
+
+	if _, ok := b.ColumnIndex("id"); ok {
+		t.Fatalf("ambiguous name should not be found")
+	}
+
+	if i, ok := b.ColumnIndex("name"); !ok || i != 2 {
+		t.Fatalf("name: 2 expected, got %d %v", i, ok)
+	}
+
+	if i, ok := b.ColumnIndex("total"); !ok || i != 5 {
+		t.Fatalf("total: 5 expected, got %d %v", i, ok)
+	}
+
+	if _, ok := b.ColumnIndex(""); ok {
+		t.Fatalf("empty name should not be found")
+	}
+
+	expected := map[string]ColumnNameInfo{
+		"id":    {Index: -1, Ambiguous: true, Indexes: []int{0, 1, 6}},
+		"name":  {Index: 2, Indexes: []int{2}},
+		"total": {Index: 5, Indexes: []int{5}},
+	}
+
+	if info := b.ColumnNameInfo(); reflect.DeepEqual(info, expected) == false {
+		t.Fatalf("%v expected, got %v", expected, info)
+	}
+
+	if info := (&Batch{}).ColumnNameInfo(); info == nil || len(info) != 0 {
+		t.Fatalf("empty map expected")
+	}
+}
+
+func Test_batch_error_annotate(t *testing.T) {
+
+	be := &BatchError{Text: "syntax error", State: 1, LineNo: 2, LinePos: 4}
+
+	expected := "     1 | SELECT a\n" +
+		"     2 | \tFROM t WHER x = 1\n" +
+		"       | \t  ^ 2:4[1] syntax error\n"
+
+	if s := be.Annotate("SELECT a\n\tFROM t WHER x = 1\n"); s != expected {
+		t.Fatalf("bad annotation\n%s", s)
+	}
+
+	be.LineNo = 9
+	if s := be.Annotate("SELECT a"); s != "     1 | SELECT a\n       | 9:4[1] syntax error\n" {
+		t.Fatalf("bad annotation for line out of range\n%s", s)
+	}
+
+	part := NewSQLpart("SELECT {{a}}").BindInt("a", 1)
+
+	if s, err := AnnotateError(part, fmt.Errorf("wrapped: %w", &BatchError{Text: "oops", LineNo: 1, LinePos: 8})); err != nil || s != "     1 | SELECT 1\n       |        ^ 1:8[0] oops\n" {
+		t.Fatalf("bad annotation %q %v", s, err)
+	}
+
+	if _, err := AnnotateError(part, fmt.Errorf("not a batch error")); err == nil {
+		t.Fatalf("error expected")
+	}
+}
+
+func Test_new_connection_with_conn(t *testing.T) {
+
+	client, server := net.Pipe()
+
+	go func() { // login rejected, the server drops the connection
+		server.Read(make([]byte, 1000))
+		server.Close()
+	}()
+
+	if _, err := NewConnectionWithConn(client, "login=john;password=bad"); err == nil {
+		t.Fatalf("login error expected")
+	} else if _, ok := err.(*LoginError); ok == false {
+		t.Fatalf("*LoginError expected, got %T %s", err, err)
+	}
+
+	client2, server2 := net.Pipe()
+	defer server2.Close()
+
+	if _, err := NewConnectionWithConn(client2, "no attributes"); err == nil {
+		t.Fatalf("error expected for bad connection string")
+	}
+
+	if _, err := client2.Write([]byte{0}); err == nil {
+		t.Fatalf("netConn should be closed on error")
+	}
+}
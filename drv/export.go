@@ -0,0 +1,134 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const TSV_NULL = `\N` // token written by WriteTSV for NULL values, as in the text format of MySQL and PostgreSQL
+
+// WriteTSV writes the records of the current recordset to w, as tab-separated values, and returns the number of records written.
+// The first line contains the column names. Each record is written on its own line, as soon as it is read by Next.
+//
+// Values are formatted by ColStringBytes, so that SetTrimFixedChar and SetTimeLayouts apply.
+// NULL values are written as TSV_NULL. In values and column names, backslash, tab, newline and carriage return are escaped as \\, \t, \n and \r, so that a line always contains one record:
+//
+//    if b, err = conn.Query("SELECT custid, name FROM mydb..customers"); err != nil {
+//        log.Fatalf("%s", err)
+//    }
+//
+//    if _, err = b.WriteTSV(os.Stdout); err != nil {
+//        log.Fatalf("%s", err)
+//    }
+//
+//    $ myexport | cut -f 2
+//
+// It must be called before the first call to Next of the recordset. It returns an error if no recordset is available, e.g. after the last one, and nothing is written.
+// The batch is not finalized, so that the next recordsets can be read after WriteTSV returns.
+//
+// The output is buffered, and flushed before WriteTSV returns, even if an error occurs while reading the records, so that the lines already written are not lost.
+//
+func (b *Batch) WriteTSV(w io.Writer) (int64, error) {
+
+	if b.Err() != nil {
+		return 0, b.Err()
+	}
+
+	if b.status != sTATUS_RECORD_LAYOUT_AVAILABLE {
+		return 0, fmt.Errorf("WriteTSV: no recordset available, it must be called before Next.")
+	}
+
+	bw := bufio.NewWriter(w)
+
+	count, err := b.writeTSV(bw)
+
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+
+	return count, err
+}
+
+// writeTSV writes the column names and the records of the current recordset to bw, for WriteTSV.
+//
+func (b *Batch) writeTSV(bw *bufio.Writer) (int64, error) {
+	var (
+		err   error
+		count int64
+		line  []byte
+	)
+
+	// header
+
+	for i, name := range b.colnameList {
+		if i > 0 {
+			line = append(line, '\t')
+		}
+		line = appendTSVField(line, []byte(name))
+	}
+	line = append(line, '\n')
+
+	if _, err = bw.Write(line); err != nil {
+		return 0, err
+	}
+
+	// records
+
+	for b.Next() {
+		line = line[:0]
+
+		for i := range b.record {
+			if i > 0 {
+				line = append(line, '\t')
+			}
+
+			val, isnull := b.ColStringBytes(i)
+
+			if isnull {
+				line = append(line, TSV_NULL...)
+				continue
+			}
+
+			line = appendTSVField(line, val)
+		}
+		line = append(line, '\n')
+
+		if _, err = bw.Write(line); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	if b.Err() != nil {
+		return count, b.Err()
+	}
+
+	return count, nil
+}
+
+// appendTSVField appends val to buff, with backslash, tab, newline and carriage return escaped.
+//
+func appendTSVField(buff []byte, val []byte) []byte {
+
+	for _, c := range val {
+		switch c {
+		case '\\':
+			buff = append(buff, '\\', '\\')
+		case '\t':
+			buff = append(buff, '\\', 't')
+		case '\n':
+			buff = append(buff, '\\', 'n')
+		case '\r':
+			buff = append(buff, '\\', 'r')
+		default:
+			buff = append(buff, c)
+		}
+	}
+
+	return buff
+}
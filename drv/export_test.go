@@ -0,0 +1,29 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"testing"
+)
+
+func Test_append_tsv_field(t *testing.T) {
+
+	tests := []struct {
+		val      string
+		expected string
+	}{
+		{"", ""},
+		{"hello world", "hello world"},
+		{"a\tb", `a\tb`},
+		{"line1\r\nline2", `line1\r\nline2`},
+		{`C:\temp`, `C:\\temp`},
+		{`\N`, `\\N`}, // cannot be confused with TSV_NULL
+	}
+
+	for _, tt := range tests {
+		if s := string(appendTSVField([]byte("x\t"), []byte(tt.val))); s != "x\t"+tt.expected {
+			t.Fatalf("%q: %q expected, got %q", tt.val, "x\t"+tt.expected, s)
+		}
+	}
+}
@@ -4,10 +4,16 @@
 package drv
 
 import (
+	"bytes"
 	"fmt"
+	"iter"
 	"time"
 	"math"
+	"reflect"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"rsql/rsqlib"
 )
@@ -34,6 +40,8 @@ const (
 	DATE
 	TIME
 	DATETIME
+
+	BOOLEAN
 )
 
 // String returns the datatype as string.
@@ -69,6 +77,8 @@ func (dt Datatype) String() string {
 		return "TIME"
 	case DATETIME:
 		return "DATETIME"
+	case BOOLEAN:
+		return "BOOLEAN"
 	default:
 		panic(fmt.Sprintf("unknown datatype %d", dt))
 	}
@@ -81,13 +91,49 @@ func (b *Batch) ColCount() int {
 	return len(b.record)
 }
 
+// RawRecord returns the fields of the current record, as decoded by the rsqlib package.
+// It is an escape hatch for advanced users who want to implement their own conversions, e.g. to read NUMERIC values into their own decimal type.
+//
+//       WARNING: the returned slice and the fields it contains are owned by the driver, and are reused and modified when the next record is read by Next.
+//       You must not modify them. If you want to keep a value, you must make a copy.
+//       When a recordset is finished, the record is discarded and RawRecord returns nil. Its fields may be reused by the next recordset.
+//
+func (b *Batch) RawRecord() []rsqlib.IField {
+
+	return b.record
+}
+
+// ValidColIndex returns true if i is a valid column index for the current recordset, that is, 0 <= i < ColCount().
+//
+func (b *Batch) ValidColIndex(i int) bool {
+
+	return i >= 0 && i < len(b.record)
+}
+
+// checkColIndex returns an error if i is not a valid column index for the current recordset.
+//
+func (b *Batch) checkColIndex(i int) error {
+
+	if i < 0 || i >= len(b.record) {
+		return fmt.Errorf("column index %d out of range [0,%d).", i, len(b.record))
+	}
+
+	return nil
+}
+
 // ColDatatype returns the datatype of the column i of the record.
 //
+// There is no array datatype. A recordset with an array column, or any datatype unknown to the driver, is rejected with an error in b.Err(), and the connection must be closed.
+//
 func (b *Batch) ColDatatype(i int) Datatype {
 	var (
 		field rsqlib.IField
 	)
 
+	if err := b.checkColIndex(i); err != nil {
+		panic(err.Error())
+	}
+
 	field = b.record[i]
 
 	switch field.Datatype() {
@@ -119,67 +165,186 @@ func (b *Batch) ColDatatype(i int) Datatype {
 		return TIME
 	case rsqlib.DTYPE_DATETIME:
 		return DATETIME
+	case rsqlib.DTYPE_BOOLEAN:
+		return BOOLEAN
 	default:
 		panic(fmt.Sprintf("unknown datatype in field %d.", i))
 	}
 }
 
+// Schema returns a description of the columns of the current recordset, like the column list of a CREATE TABLE statement.
+// It is useful to log the shape of the result of an ad-hoc query, or to create a destination table in ETL scripts.
+//
+//    (
+//        [orderid] INT,
+//        [product] VARCHAR(100),
+//        [code] CHAR(5),
+//        [price] NUMERIC(12,2),
+//        [orderdate] DATETIME
+//    )
+//
+// Column names are quoted by square brackets. Columns without name are named col1, col2, etc, according to their position.
+//
+// NULL or NOT NULL is not specified, as the server doesn't send the nullability of the columns.
+//
+// An error is returned if no recordset is available.
+//
+func (b *Batch) Schema() (string, error) {
+	var (
+		sb strings.Builder
+	)
+
+	if b.record == nil {
+		return "", fmt.Errorf("Schema not available, no recordset found.")
+	}
+
+	sb.WriteString("(\n")
+
+	for i, field := range b.record {
+		name := ""
+		if i < len(b.colnameList) {
+			name = b.colnameList[i]
+		}
+		if name == "" {
+			name = fmt.Sprintf("col%d", i+1)
+		}
+
+		sb.WriteString("    " + quoteIdentifier(name) + " " + fieldTypeString(field))
+
+		if i < len(b.record)-1 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString(")")
+
+	return sb.String(), nil
+}
+
+// fieldTypeString returns the SQL datatype of field, with precision and scale, e.g. VARCHAR(100) or NUMERIC(12,2).
+//
+func fieldTypeString(field rsqlib.IField) string {
+
+	switch field := field.(type) {
+	case *rsqlib.Void:
+		return "VOID"
+	case *rsqlib.Boolean:
+		return "BOOLEAN"
+	case *rsqlib.Varbinary:
+		return fmt.Sprintf("VARBINARY(%d)", field.Precision)
+	case *rsqlib.Varchar:
+		if field.Fixlen {
+			return fmt.Sprintf("CHAR(%d)", field.Precision)
+		}
+		return fmt.Sprintf("VARCHAR(%d)", field.Precision)
+	case *rsqlib.Bit:
+		return "BIT"
+	case *rsqlib.Tinyint:
+		return "TINYINT"
+	case *rsqlib.Smallint:
+		return "SMALLINT"
+	case *rsqlib.Int:
+		return "INT"
+	case *rsqlib.Bigint:
+		return "BIGINT"
+	case *rsqlib.Money:
+		return "MONEY"
+	case *rsqlib.Numeric:
+		return fmt.Sprintf("NUMERIC(%d,%d)", field.Precision, field.Scale)
+	case *rsqlib.Float:
+		return "FLOAT"
+	case *rsqlib.Date:
+		return "DATE"
+	case *rsqlib.Time:
+		return "TIME"
+	case *rsqlib.Datetime:
+		return "DATETIME"
+	default:
+		panic(fmt.Sprintf("unknown datatype %T", field))
+	}
+}
+
 // ColIsNull returns true if column i contains the NULL value.
 //
 func (b *Batch) ColIsNull(i int) bool {
 
+	if err := b.checkColIndex(i); err != nil {
+		panic(err.Error())
+	}
+
 	return b.record[i].IsNull()
 }
 
 // ColBool returns a bool containing the value of column i.
 // If the column is NULL, false is returned and isnull is true.
 //
-// This method can only be called on columns of type VARCHAR, BIT, TINYINT, SMALLINT, INT, BIGINT, FLOAT.
+// This method can only be called on columns of type BOOLEAN, VARCHAR, BIT, TINYINT, SMALLINT, INT, BIGINT, FLOAT.
 //
 // If column is VARCHAR, true is returned for the values '1', 't', 'T', 'TRUE', 'true', 'True'.
 // If column is a numeric type, true is returned if value is not 0. Else, false is returned.
 //
+// If the column datatype is not supported, this method panics. Use TryColBool to get an error instead.
+//
 func (b *Batch) ColBool(i int) (val bool, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColBool(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColBool is the same as ColBool, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColBool(i int) (val bool, isnull bool, err error) {
 	var (
 		field rsqlib.IField
 	)
 
+	if err = b.checkColIndex(i); err != nil {
+		return false, false, err
+	}
+
 	field = b.record[i]
 
 	if field.IsNull() {
-		return false, true
+		return false, true, nil
 	}
 
 	switch field.Datatype() {
+	case rsqlib.DTYPE_BOOLEAN:
+		return field.(*rsqlib.Boolean).Val, false, nil
+
 	case rsqlib.DTYPE_VARCHAR:
 		var res bool
-		var err error
 		if res, err = strconv.ParseBool(string(field.(*rsqlib.Varchar).Val)); err != nil {
-			return false, false
+			return false, false, nil // not an error, false is returned
 		}
 
-		return res, false
+		return res, false, nil
 
 	case rsqlib.DTYPE_BIT:
-		return field.(*rsqlib.Bit).Val != 0, false
+		return field.(*rsqlib.Bit).Val != 0, false, nil
 
 	case rsqlib.DTYPE_TINYINT:
-		return field.(*rsqlib.Tinyint).Val != 0, false
+		return field.(*rsqlib.Tinyint).Val != 0, false, nil
 
 	case rsqlib.DTYPE_SMALLINT:
-		return field.(*rsqlib.Smallint).Val != 0, false
+		return field.(*rsqlib.Smallint).Val != 0, false, nil
 
 	case rsqlib.DTYPE_INT:
-		return field.(*rsqlib.Int).Val != 0, false
+		return field.(*rsqlib.Int).Val != 0, false, nil
 
 	case rsqlib.DTYPE_BIGINT:
-		return field.(*rsqlib.Bigint).Val != 0, false
+		return field.(*rsqlib.Bigint).Val != 0, false, nil
 
 	case rsqlib.DTYPE_FLOAT:
-		return field.(*rsqlib.Float).Val != 0, false
+		return field.(*rsqlib.Float).Val != 0, false, nil
 
 	default:
-		panic(fmt.Sprintf("record field %d of type VARBINARY, MONEY, NUMERIC, DATE, TIME or DATETIME cannot be converted to bool.", i))
+		return false, false, fmt.Errorf("record field %d of type VARBINARY, MONEY, NUMERIC, DATE, TIME or DATETIME cannot be converted to bool.", i)
 	}
 }
 
@@ -191,36 +356,90 @@ func (b *Batch) ColBool(i int) (val bool, isnull bool) {
 //
 // This method can only be called on columns of type VARBINARY.
 //
+// If the column datatype is not supported, this method panics. Use TryColBinary to get an error instead.
+//
 func (b *Batch) ColBinary(i int) (val []byte, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColBinary(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColBinary is the same as ColBinary, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColBinary(i int) (val []byte, isnull bool, err error) {
 	var (
 		field rsqlib.IField
 	)
 
+	if err = b.checkColIndex(i); err != nil {
+		return nil, false, err
+	}
+
 	field = b.record[i]
 
 	if field.IsNull() {
-		return nil, true
+		return nil, true, nil
 	}
 
 	switch field.Datatype() {
 	case rsqlib.DTYPE_VARBINARY:
-		return field.(*rsqlib.Varbinary).Val, false
+		return field.(*rsqlib.Varbinary).Val, false, nil
 
 	default:
-		panic(fmt.Sprintf("record field %d is not a binary datatype.", i))
+		return nil, false, fmt.Errorf("record field %d is not a binary datatype.", i)
+	}
+}
+
+// ColBinaryInto copies the value of column i into dst[:0], and returns the resulting slice. The backing array of dst is reused if it is large enough.
+// If the column is NULL, dst[:0] is returned and isnull is true.
+//
+// Unlike ColBinary, the returned slice is not owned by the driver, and is not modified when the next record is read. It is the same copy as done by Scan for a *[]byte argument.
+// It gives explicit control over allocation, e.g. by passing the same buffer for each record.
+//
+// This method can only be called on columns of type VARBINARY.
+//
+// If the column datatype is not supported, this method panics. Use TryColBinaryInto to get an error instead.
+//
+func (b *Batch) ColBinaryInto(i int, dst []byte) (val []byte, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColBinaryInto(i, dst); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColBinaryInto is the same as ColBinaryInto, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColBinaryInto(i int, dst []byte) (val []byte, isnull bool, err error) {
+
+	if val, isnull, err = b.TryColBinary(i); err != nil {
+		return nil, false, err
 	}
+
+	return append(dst[:0], val...), isnull, nil
 }
 
 // ColString returns a string containing the value of column i.
 // If the column is NULL, an empty string is returned and isnull is true.
 //
 // This method can be called on columns of any datatype.
+// The returned string is a copy, which can be kept safely. To avoid this allocation, see ColStringBytes.
 //
 func (b *Batch) ColString(i int) (val string, isnull bool) {
 	var (
 		field rsqlib.IField
 	)
 
+	if err := b.checkColIndex(i); err != nil {
+		panic(err.Error())
+	}
+
 	field = b.record[i]
 
 	if field.IsNull() {
@@ -229,7 +448,7 @@ func (b *Batch) ColString(i int) (val string, isnull bool) {
 
 	switch field.Datatype() {
 	case rsqlib.DTYPE_VARCHAR:
-		return string(field.(*rsqlib.Varchar).Val), false
+		return string(b.varcharVal(field.(*rsqlib.Varchar))), false
 
 	case rsqlib.DTYPE_MONEY:
 		return string(field.(*rsqlib.Money).Val), false
@@ -238,7 +457,103 @@ func (b *Batch) ColString(i int) (val string, isnull bool) {
 		return string(field.(*rsqlib.Numeric).Val), false
 
 	default:
-		return field.String(), false
+		return b.fieldString(field), false
+	}
+}
+
+// SetTimeLayouts specifies the layouts used by ColString and ColStringBytes to format the values of DATE, TIME and DATETIME columns, as expected by time.Time.Format.
+// An empty layout selects the default layout for this datatype, which is "2006-01-02" for DATE, and "15:04:05" for TIME and "2006-01-02 15:04:05" for DATETIME, with nanoseconds if not 0.
+//
+//    b.SetTimeLayouts("02.01.2006", "", time.RFC3339Nano)
+//
+func (b *Batch) SetTimeLayouts(dateLayout string, timeLayout string, datetimeLayout string) {
+
+	b.dateLayout = dateLayout
+	b.timeLayout = timeLayout
+	b.datetimeLayout = datetimeLayout
+}
+
+// fieldString returns the string representation of field, using the layouts passed to SetTimeLayouts for DATE, TIME and DATETIME fields.
+//
+func (b *Batch) fieldString(field rsqlib.IField) string {
+
+	switch field := field.(type) {
+	case *rsqlib.Date:
+		if b.dateLayout != "" {
+			return field.Val.Format(b.dateLayout)
+		}
+
+	case *rsqlib.Time:
+		if b.timeLayout != "" {
+			return field.Val.Format(b.timeLayout)
+		}
+
+	case *rsqlib.Datetime:
+		if b.datetimeLayout != "" {
+			return field.Val.Format(b.datetimeLayout)
+		}
+	}
+
+	return field.String()
+}
+
+// SetTrimFixedChar specifies if the trailing spaces padding the values of fixed-length CHAR columns must be removed.
+// By default, values of CHAR columns are padded with spaces to the declared length of the column, as required by SQL.
+//
+// If trim is true, ColString, ColStringBytes and ColValue return the values of CHAR columns without trailing spaces.
+// So do Scan into a *string, SliceScan, MapScan and ScanStruct, which call them. The other accessors, e.g. TryColBool, still see the padded value.
+// VARCHAR columns are not affected.
+//
+func (b *Batch) SetTrimFixedChar(trim bool) {
+
+	b.trimFixedChar = trim
+}
+
+// varcharVal returns the value of a VARCHAR or CHAR field, without the padding spaces if the field is CHAR and b.trimFixedChar is set.
+//
+func (b *Batch) varcharVal(field *rsqlib.Varchar) []byte {
+
+	if b.trimFixedChar && field.Fixlen {
+		return bytes.TrimRight(field.Val, " ")
+	}
+
+	return field.Val
+}
+
+// ColStringBytes is the same as ColString, but it returns the underlying bytes of the field for VARCHAR, MONEY and NUMERIC columns, without allocating a new string.
+// It is useful to scan a large number of records just to hash or compare values.
+// For columns of other datatypes, a newly allocated byte slice is returned.
+//
+//       WARNING: the returned slice is owned by the driver, and is reused and modified when the next record is read by Next.
+//       You must not modify it. If you want to keep the value, use ColString, or make a copy.
+//
+func (b *Batch) ColStringBytes(i int) (val []byte, isnull bool) {
+	var (
+		field rsqlib.IField
+	)
+
+	if err := b.checkColIndex(i); err != nil {
+		panic(err.Error())
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return nil, true
+	}
+
+	switch field.Datatype() {
+	case rsqlib.DTYPE_VARCHAR:
+		return b.varcharVal(field.(*rsqlib.Varchar)), false
+
+	case rsqlib.DTYPE_MONEY:
+		return field.(*rsqlib.Money).Val, false
+
+	case rsqlib.DTYPE_NUMERIC:
+		return field.(*rsqlib.Numeric).Val, false
+
+	default:
+		return []byte(b.fieldString(field)), false
 	}
 }
 
@@ -247,46 +562,85 @@ func (b *Batch) ColString(i int) (val string, isnull bool) {
 //
 // This method can only be called on columns of type BIT, TINYINT, SMALLINT, INT, BIGINT.
 //
+// If the column datatype is not supported, this method panics. Use TryColInt64 to get an error instead.
+//
 func (b *Batch) ColInt64(i int) (val int64, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColInt64(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColInt64 is the same as ColInt64, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColInt64(i int) (val int64, isnull bool, err error) {
 	var (
 		field rsqlib.IField
 	)
 
+	if err = b.checkColIndex(i); err != nil {
+		return 0, false, err
+	}
+
 	field = b.record[i]
 
 	if field.IsNull() {
-		return 0, true
+		return 0, true, nil
 	}
 
 	switch field.Datatype() {
 	case rsqlib.DTYPE_BIT:
-		return int64(field.(*rsqlib.Bit).Val), false
+		return int64(field.(*rsqlib.Bit).Val), false, nil
 
 	case rsqlib.DTYPE_TINYINT:
-		return int64(field.(*rsqlib.Tinyint).Val), false
+		return int64(field.(*rsqlib.Tinyint).Val), false, nil
 
 	case rsqlib.DTYPE_SMALLINT:
-		return int64(field.(*rsqlib.Smallint).Val), false
+		return int64(field.(*rsqlib.Smallint).Val), false, nil
 
 	case rsqlib.DTYPE_INT:
-		return int64(field.(*rsqlib.Int).Val), false
+		return int64(field.(*rsqlib.Int).Val), false, nil
 
 	case rsqlib.DTYPE_BIGINT:
-		return int64(field.(*rsqlib.Bigint).Val), false
+		return int64(field.(*rsqlib.Bigint).Val), false, nil
 
 	default:
-		panic(fmt.Sprintf("record field %d is not an integer datatype.", i))
+		return 0, false, fmt.Errorf("record field %d is not an integer datatype.", i)
 	}
 }
 
 // ColInt is the same as ColInt64, but returns int.
 // It is just provided for convenience.
 //
+// On 32-bit platforms, if the value doesn't fit in an int, this method panics. Use TryColInt to get an error instead.
+//
 func (b *Batch) ColInt(i int) (val int, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColInt(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColInt is the same as ColInt, but returns an error instead of panicking if the column datatype is not supported, or if the value overflows int on 32-bit platforms.
+//
+func (b *Batch) TryColInt(i int) (val int, isnull bool, err error) {
+
+	val64, isnull, err := b.TryColInt64(i)
+	if err != nil {
+		return 0, false, err
+	}
 
-	val64, isnull := b.ColInt64(i)
+	if val64 < math.MinInt || val64 > math.MaxInt { // only possible if int is 32 bits
+		return 0, false, fmt.Errorf("record field %d to int: overflow.", i)
+	}
 
-	return int(val64), isnull
+	return int(val64), isnull, nil
 }
 
 // ColNumeric returns a string containing the value of column i.
@@ -296,135 +650,779 @@ func (b *Batch) ColInt(i int) (val int, isnull bool) {
 //
 // This method can only be called on columns of type BIT, TINYINT, SMALLINT, INT, BIGINT, MONEY, NUMERIC.
 //
+// If the column datatype is not supported, this method panics. Use TryColNumeric to get an error instead.
+//
 func (b *Batch) ColNumeric(i int) (val string, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColNumeric(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColNumeric is the same as ColNumeric, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColNumeric(i int) (val string, isnull bool, err error) {
 	var (
 		field rsqlib.IField
 	)
 
+	if err = b.checkColIndex(i); err != nil {
+		return "", false, err
+	}
+
 	field = b.record[i]
 
 	if field.IsNull() {
-		return "", true
+		return "", true, nil
 	}
 
 	switch field.Datatype() {
 	case rsqlib.DTYPE_BIT, rsqlib.DTYPE_TINYINT, rsqlib.DTYPE_SMALLINT, rsqlib.DTYPE_INT, rsqlib.DTYPE_BIGINT:
-		return field.String(), false
+		return field.String(), false, nil
 
 	case rsqlib.DTYPE_MONEY:
-		return string(field.(*rsqlib.Money).Val), false
+		return string(field.(*rsqlib.Money).Val), false, nil
 
 	case rsqlib.DTYPE_NUMERIC:
-		return string(field.(*rsqlib.Numeric).Val), false
+		return string(field.(*rsqlib.Numeric).Val), false, nil
 
 	default:
-		panic(fmt.Sprintf("record field %d is not an integer, money or numeric datatype.", i))
+		return "", false, fmt.Errorf("record field %d is not an integer, money or numeric datatype.", i)
 	}
 }
 
-// ColFloat64 returns a float64 containing the value of column i.
+// ColMoney returns the value of column i, split up into units and nanos, in the same way as google.type.Money.
+// units is the whole part of the amount, and nanos is the number of nano (10^-9) units of the amount. Both have the same sign.
+// E.g. -12.75 is returned as units -12 and nanos -750000000.
 // If the column is NULL, 0 is returned and isnull is true.
 //
-// This method can only be called on columns of type FLOAT.
+// The money text sent by the server is parsed independently of the locale. The supported formats are:
 //
-func (b *Batch) ColFloat64(i int) (val float64, isnull bool) {
+//    1234.5678           plain number, '.' is the decimal separator
+//    -1234.5678          leading sign '+' or '-'
+//    1,234,567.50        ',', ' ' or '\'' are accepted as thousands separators, and are ignored
+//    $1,234.50  -$12.5   an optional leading currency symbol (any non-digit character, e.g. '$', '€', '£'), before or after the sign
+//
+// The fractional part cannot have more digits than the Scale of the column. Else, an error is returned.
+//
+// This method can only be called on columns of type MONEY. Else, an error is returned.
+//
+func (b *Batch) ColMoney(i int) (units int64, nanos int32, isnull bool, err error) {
 	var (
 		field rsqlib.IField
 	)
 
+	if err = b.checkColIndex(i); err != nil {
+		return 0, 0, false, err
+	}
+
 	field = b.record[i]
 
+	if field.Datatype() != rsqlib.DTYPE_MONEY {
+		return 0, 0, false, fmt.Errorf("record field %d is not a money datatype.", i)
+	}
+
 	if field.IsNull() {
-		return 0, true
+		return 0, 0, true, nil
 	}
 
-	switch field.Datatype() {
-	case rsqlib.DTYPE_FLOAT:
-		return field.(*rsqlib.Float).Val, false
+	money := field.(*rsqlib.Money)
 
-	default:
-		panic(fmt.Sprintf("record field %d is not a float datatype.", i))
+	if units, nanos, err = parseMoney(string(money.Val), money.Scale); err != nil {
+		return 0, 0, false, fmt.Errorf("record field %d: %s", i, err)
 	}
+
+	return units, nanos, false, nil
 }
 
-// ColDatetime returns a time.Time containing the value of column i, with location UTC.
-// If the column is NULL, the zero time.Time value (0001-01-01) is returned and isnull is true.
-//
-// For columns of datatype TIME, the returned value is the time on 1900.01.01 UTC, which is the zero date on SQL Server.
+// parseMoney parses the money string s, as described in ColMoney.
 //
-// This method can only be called on columns of type DATE, TIME, DATETIME.
-//
-func (b *Batch) ColDatetimeUTC(i int) (val time.Time, isnull bool) {
+func parseMoney(s string, scale uint16) (units int64, nanos int32, err error) {
 	var (
-		field rsqlib.IField
+		negative    bool
+		signFound   bool
+		symbolFound bool
+		intDigits   []byte
+		fracDigits  []byte
+		dotFound    bool
 	)
 
-	field = b.record[i]
+	orig := s
+	s = strings.TrimSpace(s)
 
-	if field.IsNull() {
-		return time.Time{}, true
+	// leading sign and currency symbol, in any order
+
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+
+		switch {
+		case r == '+' || r == '-':
+			if signFound {
+				return 0, 0, fmt.Errorf("money %q is invalid.", orig)
+			}
+			signFound = true
+			negative = r == '-'
+
+		case unicode.IsDigit(r) || r == '.':
+			goto number
+
+		case unicode.IsSpace(r):
+
+		default:
+			if symbolFound {
+				return 0, 0, fmt.Errorf("money %q is invalid.", orig)
+			}
+			symbolFound = true
+		}
+
+		s = s[size:]
 	}
 
-	switch field.Datatype() {
-	case rsqlib.DTYPE_DATE:
-		return field.(*rsqlib.Date).Val, false
+number:
+	if s == "" {
+		return 0, 0, fmt.Errorf("money %q has no digits.", orig)
+	}
 
-	case rsqlib.DTYPE_TIME:
-		return field.(*rsqlib.Time).Val, false // year is 1900.01.01
+	for j := 0; j < len(s); j++ {
+		c := s[j]
 
-	case rsqlib.DTYPE_DATETIME:
-		return field.(*rsqlib.Datetime).Val, false
+		switch {
+		case c >= '0' && c <= '9':
+			if dotFound {
+				fracDigits = append(fracDigits, c)
+			} else {
+				intDigits = append(intDigits, c)
+			}
 
-	default:
-		panic(fmt.Sprintf("record field %d is not a date, time or datetime datatype.", i))
+		case c == '.':
+			if dotFound {
+				return 0, 0, fmt.Errorf("money %q is invalid.", orig)
+			}
+			dotFound = true
+
+		case c == ',' || c == ' ' || c == '\'':
+			if dotFound { // thousands separators are only allowed in the whole part
+				return 0, 0, fmt.Errorf("money %q is invalid.", orig)
+			}
+
+		default:
+			return 0, 0, fmt.Errorf("money %q is invalid.", orig)
+		}
 	}
-}
 
-// ColDatetime returns the same value as ColDatetimeUTC, but for columns of datatype DATE and DATETIME, the Time location is set to local time.
-//
-// For columns of datatype TIME, the returned value has location in UTC.
-//
-func (b *Batch) ColDatetime(i int) (val time.Time, isnull bool) {
-	var (
-		field rsqlib.IField
-	)
+	if len(intDigits) == 0 && len(fracDigits) == 0 {
+		return 0, 0, fmt.Errorf("money %q has no digits.", orig)
+	}
 
-	field = b.record[i]
+	// fractional part
 
-	if field.IsNull() {
-		return time.Time{}, true
+	fracDigits = []byte(strings.TrimRight(string(fracDigits), "0"))
+
+	if len(fracDigits) > int(scale) || len(fracDigits) > 9 {
+		return 0, 0, fmt.Errorf("money %q has more fractional digits than scale %d.", orig, scale)
 	}
 
-	if field.Datatype() == rsqlib.DTYPE_TIME { // if TIME, the result is in UTC, because computation on time should be independent of summer time
-		return field.(*rsqlib.Time).Val, false // year is 1900.01.01, UTC
+	for j := 0; j < 9; j++ {
+		nanos *= 10
+		if j < len(fracDigits) {
+			nanos += int32(fracDigits[j] - '0')
+		}
 	}
 
-	valUTC, isnull := b.ColDatetimeUTC(i)
+	// whole part
 
-	if isnull { // never happens
-		panic("impossible: DATE or DATETIME is NULL.")
+	if len(intDigits) > 0 {
+		if units, err = strconv.ParseInt(string(intDigits), 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("money %q overflows int64.", orig)
+		}
+	}
+
+	if negative {
+		units = -units
+		nanos = -nanos
 	}
 
-	return LocalizeTime(valUTC), isnull
+	return units, nanos, nil
 }
 
-// LocalizeTime is a utility function that returns a time.Time with same year, month, day, hour, minute, second, ns as t, but as seen in local time.
-// Most often, the absolute time of the result will be shifted so that the presentation time in local time is the same.
+// ColFloat64 returns a float64 containing the value of column i.
+// If the column is NULL, 0 is returned and isnull is true.
 //
-//    t := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
-//    t2 := drv.LocalizeTime(t)
+// This method can only be called on columns of type FLOAT.
+//
+// By default, NaN and Infinite values are returned as is. If SetRejectNonFinite(true) has been called, they are an error.
+//
+// If the column datatype is not supported, this method panics. Use TryColFloat64 to get an error instead.
+//
+func (b *Batch) ColFloat64(i int) (val float64, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColFloat64(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColFloat64 is the same as ColFloat64, but returns an error instead of panicking if the column datatype is not supported, or if the value is NaN or Infinite and SetRejectNonFinite(true) has been called.
+//
+func (b *Batch) TryColFloat64(i int) (val float64, isnull bool, err error) {
+	var (
+		field rsqlib.IField
+	)
+
+	if err = b.checkColIndex(i); err != nil {
+		return 0, false, err
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return 0, true, nil
+	}
+
+	switch field.Datatype() {
+	case rsqlib.DTYPE_FLOAT:
+		val = field.(*rsqlib.Float).Val
+
+		if b.rejectNonFinite && (math.IsNaN(val) || math.IsInf(val, 0)) {
+			return 0, false, fmt.Errorf("record field %d: invalid float64, is %v.", i, val)
+		}
+
+		return val, false, nil
+
+	default:
+		return 0, false, fmt.Errorf("record field %d is not a float datatype.", i)
+	}
+}
+
+// SetRejectNonFinite specifies if NaN and Infinite values of FLOAT columns are an error, so that bad data is caught early instead of silently propagating through computations.
+// By default, they are returned as is, as the server sends them.
+//
+// If reject is true, ColFloat64 panics, and TryColFloat64, ColFloat64Lax and Scan with a *float64 argument return an error, for a NaN or Infinite value.
+// ColValue, SliceScan and MapScan, which cannot return an error for a column, are not affected.
+//
+// It is the reading counterpart of BindFloat64, which rejects these values.
+//
+func (b *Batch) SetRejectNonFinite(reject bool) {
+
+	b.rejectNonFinite = reject
+}
+
+// ColFloat64Lax returns a float64 containing the value of column i, converted from any numeric datatype.
+// If the column is NULL, 0 is returned and isnull is true.
+//
+// Unlike ColFloat64, this method also accepts columns of type BIT, TINYINT, SMALLINT, INT, BIGINT, MONEY and NUMERIC.
+//
+//      WARNING: the conversion is lossy. float64 has about 15 significant digits, so that large BIGINT, MONEY or NUMERIC values are rounded to the nearest float64.
+//      It is intended for charting or statistics. For exact values, use ColInt64, ColMoney or ColNumeric.
+//
+// If the column datatype is not numeric, an error is returned.
+//
+func (b *Batch) ColFloat64Lax(i int) (val float64, isnull bool, err error) {
+	var (
+		field rsqlib.IField
+	)
+
+	if err = b.checkColIndex(i); err != nil {
+		return 0, false, err
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return 0, true, nil
+	}
+
+	switch field.Datatype() {
+	case rsqlib.DTYPE_FLOAT:
+		return b.TryColFloat64(i)
+
+	case rsqlib.DTYPE_BIT, rsqlib.DTYPE_TINYINT, rsqlib.DTYPE_SMALLINT, rsqlib.DTYPE_INT, rsqlib.DTYPE_BIGINT:
+		ival, _, err := b.TryColInt64(i)
+		if err != nil {
+			return 0, false, err
+		}
+		return float64(ival), false, nil
+
+	case rsqlib.DTYPE_MONEY:
+		units, nanos, _, err := b.ColMoney(i)
+		if err != nil {
+			return 0, false, err
+		}
+		return float64(units) + float64(nanos)/1e9, false, nil
+
+	case rsqlib.DTYPE_NUMERIC:
+		fval, err := strconv.ParseFloat(string(field.(*rsqlib.Numeric).Val), 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("record field %d: cannot convert NUMERIC \"%s\" to float64.", i, field.(*rsqlib.Numeric).Val)
+		}
+		return fval, false, nil
+
+	default:
+		return 0, false, fmt.Errorf("record field %d is not a numeric datatype.", i)
+	}
+}
+
+// ColDatetime returns a time.Time containing the value of column i, with location UTC.
+// If the column is NULL, the zero time.Time value (0001-01-01) is returned and isnull is true.
+//
+// For columns of datatype TIME, the returned value is the time on 1900.01.01 UTC, which is the zero date on SQL Server.
+//
+// This method can only be called on columns of type DATE, TIME, DATETIME.
+//
+// If the column datatype is not supported, this method panics. Use TryColDatetimeUTC to get an error instead.
+//
+func (b *Batch) ColDatetimeUTC(i int) (val time.Time, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColDatetimeUTC(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColDatetimeUTC is the same as ColDatetimeUTC, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColDatetimeUTC(i int) (val time.Time, isnull bool, err error) {
+	var (
+		field rsqlib.IField
+	)
+
+	if err = b.checkColIndex(i); err != nil {
+		return time.Time{}, false, err
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return time.Time{}, true, nil
+	}
+
+	switch field.Datatype() {
+	case rsqlib.DTYPE_DATE:
+		return field.(*rsqlib.Date).Val, false, nil
+
+	case rsqlib.DTYPE_TIME:
+		return field.(*rsqlib.Time).Val, false, nil // year is 1900.01.01
+
+	case rsqlib.DTYPE_DATETIME:
+		return field.(*rsqlib.Datetime).Val, false, nil
+
+	default:
+		return time.Time{}, false, fmt.Errorf("record field %d is not a date, time or datetime datatype.", i)
+	}
+}
+
+// ColDatetime returns the same value as ColDatetimeUTC, but for columns of datatype DATE and DATETIME, the Time location is set to local time.
+//
+// For columns of datatype TIME, the returned value has location in UTC.
+//
+// If the column datatype is not supported, this method panics. Use TryColDatetime to get an error instead.
+//
+func (b *Batch) ColDatetime(i int) (val time.Time, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColDatetime(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColDatetime is the same as ColDatetime, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColDatetime(i int) (val time.Time, isnull bool, err error) {
+
+	return b.TryColDatetimeIn(i, time.Local)
+}
+
+// ColDatetimeIn returns the same value as ColDatetimeUTC, but for columns of datatype DATE and DATETIME, the Time location is set to loc.
+// The year, month, day, hour, minute, second and nanosecond are kept, as with LocalizeTimeIn.
+//
+// It is useful for servers handling clients in several timezones, as it doesn't depend on time.Local.
+//
+// For columns of datatype TIME, the returned value has location in UTC.
+//
+// If the column datatype is not supported or loc is nil, this method panics. Use TryColDatetimeIn to get an error instead.
+//
+func (b *Batch) ColDatetimeIn(i int, loc *time.Location) (val time.Time, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColDatetimeIn(i, loc); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColDatetimeIn is the same as ColDatetimeIn, but returns an error instead of panicking if the column datatype is not supported or loc is nil.
+//
+func (b *Batch) TryColDatetimeIn(i int, loc *time.Location) (val time.Time, isnull bool, err error) {
+	var (
+		field  rsqlib.IField
+		valUTC time.Time
+	)
+
+	if loc == nil {
+		return time.Time{}, false, fmt.Errorf("record field %d: location cannot be nil.", i)
+	}
+
+	if err = b.checkColIndex(i); err != nil {
+		return time.Time{}, false, err
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return time.Time{}, true, nil
+	}
+
+	if field.Datatype() == rsqlib.DTYPE_TIME { // if TIME, the result is in UTC, because computation on time should be independent of summer time
+		return field.(*rsqlib.Time).Val, false, nil // year is 1900.01.01, UTC
+	}
+
+	if valUTC, isnull, err = b.TryColDatetimeUTC(i); err != nil {
+		return time.Time{}, false, err
+	}
+
+	if isnull { // never happens
+		panic("impossible: DATE or DATETIME is NULL.")
+	}
+
+	return LocalizeTimeIn(valUTC, loc), isnull, nil
+}
+
+// ColUnixSeconds returns the number of seconds elapsed since January 1, 1970 UTC, for the value of column i. The fractional second is truncated.
+// If the column is NULL, 0 is returned and isnull is true.
+//
+// DATE and DATETIME values have no timezone, they are considered as UTC, as returned by ColDatetimeUTC.
+//
+// This method can only be called on columns of type DATE and DATETIME.
+//
+// If the column datatype is not supported, this method panics. Use TryColUnixSeconds to get an error instead.
+//
+func (b *Batch) ColUnixSeconds(i int) (val int64, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColUnixSeconds(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColUnixSeconds is the same as ColUnixSeconds, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColUnixSeconds(i int) (val int64, isnull bool, err error) {
+	var (
+		t time.Time
+	)
+
+	if t, isnull, err = b.colDateOrDatetimeUTC(i); err != nil || isnull {
+		return 0, isnull, err
+	}
+
+	return t.Unix(), false, nil
+}
+
+// ColUnixNano returns the number of nanoseconds elapsed since January 1, 1970 UTC, for the value of column i.
+// If the column is NULL, 0 is returned and isnull is true.
+//
+// DATE and DATETIME values have no timezone, they are considered as UTC, as returned by ColDatetimeUTC.
+//
+// This method can only be called on columns of type DATE and DATETIME, whose value is between the years 1678 and 2262, as an int64 cannot hold other values in nanoseconds.
+//
+// If the column datatype is not supported or the value is out of range, this method panics. Use TryColUnixNano to get an error instead.
+//
+func (b *Batch) ColUnixNano(i int) (val int64, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColUnixNano(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColUnixNano is the same as ColUnixNano, but returns an error instead of panicking if the column datatype is not supported or the value is out of range.
+//
+func (b *Batch) TryColUnixNano(i int) (val int64, isnull bool, err error) {
+	var (
+		t time.Time
+	)
+
+	if t, isnull, err = b.colDateOrDatetimeUTC(i); err != nil || isnull {
+		return 0, isnull, err
+	}
+
+	if t.Before(minUnixNanoTime) || t.After(maxUnixNanoTime) {
+		return 0, false, fmt.Errorf("record field %d: %s out of range for Unix time in nanoseconds.", i, t.Format("2006-01-02 15:04:05"))
+	}
+
+	return t.UnixNano(), false, nil
+}
+
+var (
+	minUnixNanoTime = time.Unix(0, math.MinInt64).UTC() // range of time.Time that UnixNano can represent
+	maxUnixNanoTime = time.Unix(0, math.MaxInt64).UTC()
+)
+
+// colDateOrDatetimeUTC returns the value of column i, which must be a DATE or DATETIME column.
+//
+func (b *Batch) colDateOrDatetimeUTC(i int) (val time.Time, isnull bool, err error) {
+	var (
+		field rsqlib.IField
+	)
+
+	if err = b.checkColIndex(i); err != nil {
+		return time.Time{}, false, err
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return time.Time{}, true, nil
+	}
+
+	switch field.Datatype() {
+	case rsqlib.DTYPE_DATE:
+		return field.(*rsqlib.Date).Val, false, nil
+
+	case rsqlib.DTYPE_DATETIME:
+		return field.(*rsqlib.Datetime).Val, false, nil
+
+	default:
+		return time.Time{}, false, fmt.Errorf("record field %d is not a date or datetime datatype.", i)
+	}
+}
+
+// ColTimeDuration returns a time.Duration containing the value of column i, as the duration since midnight.
+// If the column is NULL, 0 is returned and isnull is true.
+//
+// E.g. for the TIME value '13:30:05.5', the result is 13h30m5.5s.
+//
+// This method can only be called on columns of type TIME.
+//
+// If the column datatype is not supported, this method panics. Use TryColTimeDuration to get an error instead.
+//
+func (b *Batch) ColTimeDuration(i int) (val time.Duration, isnull bool) {
+	var err error
+
+	if val, isnull, err = b.TryColTimeDuration(i); err != nil {
+		panic(err.Error())
+	}
+
+	return val, isnull
+}
+
+// TryColTimeDuration is the same as ColTimeDuration, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColTimeDuration(i int) (val time.Duration, isnull bool, err error) {
+	var (
+		field rsqlib.IField
+	)
+
+	if err = b.checkColIndex(i); err != nil {
+		return 0, false, err
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return 0, true, nil
+	}
+
+	switch field.Datatype() {
+	case rsqlib.DTYPE_TIME:
+		t := field.(*rsqlib.Time).Val // year is 1900.01.01, UTC
+
+		hour, minute, second := t.Clock()
+		val = time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second + time.Duration(t.Nanosecond())
+
+		return val, false, nil
+
+	default:
+		return 0, false, fmt.Errorf("record field %d is not a time datatype.", i)
+	}
+}
+
+// ColFractionalScale returns the number of significant fractional-second digits (0 to 9) of the value of column i.
+// If the column is NULL, 0 is returned and isnull is true.
+//
+// E.g. for '2006-01-02T15:04:05.120', the result is 2, and for '2006-01-02T15:04:05', it is 0.
+//
+// The server doesn't send the fractional-second precision declared for DATETIME and TIME columns, so it is inferred from the value. It is useful to decide whether to print milliseconds or nanoseconds.
+//
+// This method can only be called on columns of type TIME and DATETIME.
+//
+// If the column datatype is not supported, this method panics. Use TryColFractionalScale to get an error instead.
+//
+func (b *Batch) ColFractionalScale(i int) (scale int, isnull bool) {
+	var err error
+
+	if scale, isnull, err = b.TryColFractionalScale(i); err != nil {
+		panic(err.Error())
+	}
+
+	return scale, isnull
+}
+
+// TryColFractionalScale is the same as ColFractionalScale, but returns an error instead of panicking if the column datatype is not supported.
+//
+func (b *Batch) TryColFractionalScale(i int) (scale int, isnull bool, err error) {
+	var (
+		field rsqlib.IField
+		ns    int
+	)
+
+	if err = b.checkColIndex(i); err != nil {
+		return 0, false, err
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return 0, true, nil
+	}
+
+	switch field.Datatype() {
+	case rsqlib.DTYPE_TIME:
+		ns = field.(*rsqlib.Time).Val.Nanosecond()
+
+	case rsqlib.DTYPE_DATETIME:
+		ns = field.(*rsqlib.Datetime).Val.Nanosecond()
+
+	default:
+		return 0, false, fmt.Errorf("record field %d is not a time or datetime datatype.", i)
+	}
+
+	if ns == 0 {
+		return 0, false, nil
+	}
+
+	scale = 9
+	for ns%10 == 0 { // remove trailing zeros
+		ns /= 10
+		scale--
+	}
+
+	return scale, false, nil
+}
+
+// ColValue returns the value of column i, as the natural Go type for the column datatype.
+// If the column is NULL, nil is returned and isnull is true.
+//
+// The returned value is:
+//
+//     bool        for BIT and BOOLEAN
+//     int64       for TINYINT, SMALLINT, INT, BIGINT
+//     float64     for FLOAT
+//     string      for VARCHAR, MONEY, NUMERIC
+//     []byte      for VARBINARY. It is a copy, which can be kept by the caller.
+//     time.Time   for DATE, TIME, DATETIME. It is the same value as returned by ColDatetime, or by ColDatetimeIn if a location has been set by Connection.SetTimeLocation.
+//
+// This method can be called on columns of any datatype.
+//
+func (b *Batch) ColValue(i int) (val interface{}, isnull bool) {
+	var (
+		field rsqlib.IField
+	)
+
+	if err := b.checkColIndex(i); err != nil {
+		panic(err.Error())
+	}
+
+	field = b.record[i]
+
+	if field.IsNull() {
+		return nil, true
+	}
+
+	switch field := field.(type) {
+	case *rsqlib.Boolean:
+		return field.Val, false
+
+	case *rsqlib.Bit:
+		return field.Val != 0, false
+
+	case *rsqlib.Tinyint:
+		return int64(field.Val), false
+
+	case *rsqlib.Smallint:
+		return int64(field.Val), false
+
+	case *rsqlib.Int:
+		return int64(field.Val), false
+
+	case *rsqlib.Bigint:
+		return field.Val, false
+
+	case *rsqlib.Float:
+		return field.Val, false
+
+	case *rsqlib.Varchar:
+		return string(b.varcharVal(field)), false
+
+	case *rsqlib.Money:
+		return string(field.Val), false
+
+	case *rsqlib.Numeric:
+		return string(field.Val), false
+
+	case *rsqlib.Varbinary:
+		return append([]byte(nil), field.Val...), false
+
+	case *rsqlib.Date, *rsqlib.Time, *rsqlib.Datetime:
+		val, isnull := b.ColDatetimeIn(i, b.timeLocation())
+		return val, isnull
+
+	default:
+		panic(fmt.Sprintf("unknown datatype in field %d.", i))
+	}
+}
+
+// timeLocation returns the location of the DATE and DATETIME values returned by Scan and ColValue, set by Connection.SetTimeLocation.
+//
+func (b *Batch) timeLocation() *time.Location {
+
+	if b.conn == nil || b.conn.timeLocation == nil {
+		return time.Local
+	}
+
+	return b.conn.timeLocation
+}
+
+// LocalizeTime is a utility function that returns a time.Time with same year, month, day, hour, minute, second, ns as t, but as seen in local time.
+// Most often, the absolute time of the result will be shifted so that the presentation time in local time is the same.
+//
+//    t := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+//    t2 := drv.LocalizeTime(t)
 //
 //    fmt.Println(t)             // 2009-11-10 23:00:00 +0000 UTC
 //    fmt.Println(t2)            // 2009-11-10 23:00:00 +0100 CET
 //    fmt.Println(t.Equal(t2))   // false, because absolute times are different
 //
 func LocalizeTime(t time.Time) time.Time {
+
+	return LocalizeTimeIn(t, time.Local)
+}
+
+// LocalizeTimeIn is the same as LocalizeTime, but the result is seen in location loc instead of local time.
+//
+// loc must not be nil.
+//
+func LocalizeTimeIn(t time.Time, loc *time.Location) time.Time {
 	var res time.Time
 
 	year, month, day := t.Date()
 	hour, minute, second := t.Clock()
 	nanosecond := t.Nanosecond()
-	res = time.Date(year, month, day, hour, minute, second, nanosecond, time.Local)
+	res = time.Date(year, month, day, hour, minute, second, nanosecond, loc)
 
 	return res
 }
@@ -437,6 +1435,15 @@ func LocalizeTime(t time.Time) time.Time {
 //
 //     &bool, &[]byte, &string, &int8, &int16, &int32, &int64, &int, &uint8, &uint16, &uint32, &uint64, &uint, &float64, &time.Time
 //
+// If the datatype of a column cannot be converted to the type of its dest argument, an error is returned.
+//
+// If there is a single dest argument, and it is a pointer to a struct, Scan(&myStruct) is the same as ScanStruct(&myStruct).
+// *time.Time is not considered as a pointer to a struct, and is scanned as a single column.
+//
+//       WARNING: for a *[]byte dest argument, the bytes are copied into the backing array of the slice it points to, which is reused for each record.
+//       So, if you scan the same []byte variable for each record and append it to a slice of results, all the elements of this slice share the same backing array, and contain the value of the last record.
+//       In this case, use ScanCopy, which always allocates a new []byte.
+//
 // Example:
 //
 //	func main() {
@@ -507,6 +1514,23 @@ func LocalizeTime(t time.Time) time.Time {
 //
 func (b *Batch) Scan(dest ...interface{}) error {
 
+	return b.scan(false, dest...)
+}
+
+// ScanCopy is the same as Scan, but a new []byte is always allocated for *[]byte dest arguments, instead of reusing their backing array.
+//
+// It is the right choice for loops appending scanned records to a slice of results. Strings are always new copies, for both Scan and ScanCopy.
+//
+func (b *Batch) ScanCopy(dest ...interface{}) error {
+
+	return b.scan(true, dest...)
+}
+
+// scan implements Scan and ScanCopy.
+// If copyBytes is true, a new []byte is allocated for *[]byte dest arguments.
+//
+func (b *Batch) scan(copyBytes bool, dest ...interface{}) error {
+
 	if b.err != nil {
 		return b.err
 	}
@@ -515,115 +1539,268 @@ func (b *Batch) Scan(dest ...interface{}) error {
 		return fmt.Errorf("scan: record not available.")
 	}
 
+	if len(dest) == 1 && isStructPointer(dest[0]) {
+		return b.ScanStruct(dest[0])
+	}
+
 	if len(dest) != b.ColCount() {
 		return fmt.Errorf("scan: dest arguments count must be the same as record columns count (%d).", b.ColCount())
 	}
 
 	for i, dt := range dest {
-		switch dt := dt.(type) {
+		if err := b.scanColumn(copyBytes, i, dt); err != nil {
+			return err
+		}
+	}
 
-		// bool
+	return nil
+}
 
-		case *bool:
-			val, _ := b.ColBool(i)
-			*dt = val
+// scanColumn copies the column i of the current record into dt, which is a pointer of a type supported by Scan.
+//
+func (b *Batch) scanColumn(copyBytes bool, i int, dt interface{}) error {
 
-		// byte string
+	switch dt := dt.(type) {
 
-		case *[]byte:
-			val, _ := b.ColBinary(i)
-			*dt = append((*dt)[:0], val...) // copy bytes to dest
+	// bool
 
-		// string
+	case *bool:
+		val, _, err := b.TryColBool(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		*dt = val
 
-		case *string:
-			val, _ := b.ColString(i)
-			*dt = val
+	// byte string
 
-		// signed int
+	case *[]byte:
+		val, _, err := b.TryColBinary(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if copyBytes {
+			*dt = append([]byte(nil), val...) // copy bytes to a new slice
+		} else {
+			*dt = append((*dt)[:0], val...) // copy bytes to dest, reusing its backing array
+		}
 
-		case *int8:
-			val, _ := b.ColInt64(i)
-			if val < math.MinInt8 || val > math.MaxInt8 {
-				return fmt.Errorf("scan: column %d to int8: overflow.", i)
-			}
-			*dt = int8(val)
+	// string
 
-		case *int16:
-			val, _ := b.ColInt64(i)
-			if val < math.MinInt16 || val > math.MaxInt16 {
-				return fmt.Errorf("scan: column %d to int16: overflow.", i)
-			}
-			*dt = int16(val)
+	case *string:
+		val, _ := b.ColString(i)
+		*dt = val
 
-		case *int32:
-			val, _ := b.ColInt64(i)
-			if val < math.MinInt32 || val > math.MaxInt32 {
-				return fmt.Errorf("scan: column %d to int32: overflow.", i)
-			}
-			*dt = int32(val)
+	// signed int
 
-		case *int64:
-			val, _ := b.ColInt64(i)
-			*dt = val
+	case *int8:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if val < math.MinInt8 || val > math.MaxInt8 {
+			return fmt.Errorf("scan: column %d to int8: overflow.", i)
+		}
+		*dt = int8(val)
 
-		case *int:
-			val, _ := b.ColInt(i)
-			*dt = val
+	case *int16:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if val < math.MinInt16 || val > math.MaxInt16 {
+			return fmt.Errorf("scan: column %d to int16: overflow.", i)
+		}
+		*dt = int16(val)
 
-		// unsigned int
+	case *int32:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if val < math.MinInt32 || val > math.MaxInt32 {
+			return fmt.Errorf("scan: column %d to int32: overflow.", i)
+		}
+		*dt = int32(val)
 
-		case *uint8:
-			val, _ := b.ColInt64(i)
-			if val < 0 || val > math.MaxUint8 {
-				return fmt.Errorf("scan: column %d to uint8: overflow.", i)
-			}
-			*dt = uint8(val)
+	case *int64:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		*dt = val
 
-		case *uint16:
-			val, _ := b.ColInt64(i)
-			if val <0 || val > math.MaxUint16 {
-				return fmt.Errorf("scan: column %d to uint16: overflow.", i)
-			}
-			*dt = uint16(val)
+	case *int:
+		val, _, err := b.TryColInt(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		*dt = val
 
-		case *uint32:
-			val, _ := b.ColInt64(i)
-			if val < 0 || val > math.MaxUint32 {
-				return fmt.Errorf("scan: column %d to uint32: overflow.", i)
-			}
-			*dt = uint32(val)
+	// unsigned int
 
-		case *uint64:
-			val, _ := b.ColInt64(i)
-			if val < 0 {
-				return fmt.Errorf("scan: column %d to uint64: overflow.", i)
-			}
-			*dt = uint64(val)
+	case *uint8:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if val < 0 || val > math.MaxUint8 {
+			return fmt.Errorf("scan: column %d to uint8: overflow.", i)
+		}
+		*dt = uint8(val)
 
-		case *uint:
-			val, _ := b.ColInt64(i)
-			if val < 0 {
-				return fmt.Errorf("scan: column %d to uint64: overflow.", i)
-			}
-			*dt = uint(val)
+	case *uint16:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if val <0 || val > math.MaxUint16 {
+			return fmt.Errorf("scan: column %d to uint16: overflow.", i)
+		}
+		*dt = uint16(val)
 
-		// float64
+	case *uint32:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if val < 0 || val > math.MaxUint32 {
+			return fmt.Errorf("scan: column %d to uint32: overflow.", i)
+		}
+		*dt = uint32(val)
 
-		case *float64:
-			val, _ := b.ColFloat64(i)
-			*dt = val
+	case *uint64:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if val < 0 {
+			return fmt.Errorf("scan: column %d to uint64: overflow.", i)
+		}
+		*dt = uint64(val)
 
-		// time.Time
+	case *uint:
+		val, _, err := b.TryColInt64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		if val < 0 || uint64(val) > math.MaxUint { // val > math.MaxUint only possible if uint is 32 bits
+			return fmt.Errorf("scan: column %d to uint: overflow.", i)
+		}
+		*dt = uint(val)
 
-		case *time.Time:
-			val, _ := b.ColDatetime(i)
-			*dt = val
+	// float64
 
-		// default
+	case *float64:
+		val, _, err := b.TryColFloat64(i)
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		*dt = val
 
-		default:
-			return fmt.Errorf("scan: destination type not supported.")
+	// time.Time
+
+	case *time.Time:
+		val, _, err := b.TryColDatetimeIn(i, b.timeLocation())
+		if err != nil {
+			return fmt.Errorf("scan: %s", err)
+		}
+		*dt = val
+
+	// default
+
+	default:
+		return fmt.Errorf("scan: destination type not supported.")
+	}
+
+	return nil
+}
+
+// isStructPointer returns true if v is a pointer to a struct other than time.Time.
+//
+func isStructPointer(v interface{}) bool {
+
+	rt := reflect.TypeOf(v)
+
+	return rt != nil && rt.Kind() == reflect.Ptr && rt.Elem().Kind() == reflect.Struct && rt.Elem() != timeType
+}
+
+// ScanStruct copies the columns of the current record into the fields of the struct pointed to by dest.
+//
+// Each field with a tag `rsql:"name"` receives the column with this name. Fields without tag, or with tag `rsql:"-"`, are left unchanged.
+// Columns without corresponding field are ignored.
+//
+//    type Order struct {
+//        Customer  int       `rsql:"custid"`
+//        OrderDate time.Time `rsql:"odate"`
+//        Total     float64   `rsql:"total"`
+//        Comment   *string   `rsql:"comment"`  // nil if NULL
+//    }
+//
+//    for b.Next() {
+//        var order Order
+//
+//        if err = b.ScanStruct(&order); err != nil {
+//            log.Fatalf("%s", err)
+//        }
+//        orders = append(orders, order)
+//    }
+//
+// The fields can be of any type supported by Scan, or a pointer to such a type, which is set to nil if the column is NULL.
+// []byte fields always receive a new copy, as with ScanCopy.
+//
+// An error is returned if a tag names a column which doesn't exist in the recordset, or whose name is ambiguous.
+// As reflection cannot set unexported fields, an unexported field with a `rsql` tag is also an error.
+//
+func (b *Batch) ScanStruct(dest interface{}) error {
+
+	if b.err != nil {
+		return b.err
+	}
+
+	if b.status != sTATUS_RECORD_AVAILABLE {
+		return fmt.Errorf("scan: record not available.")
+	}
+
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("scan: dest must be a non-nil pointer to a struct, not %T.", dest)
+	}
+
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for k := 0; k < rt.NumField(); k++ {
+		name := rt.Field(k).Tag.Get("rsql")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if rt.Field(k).PkgPath != "" { // unexported field
+			return fmt.Errorf("scan: field %s with tag `rsql:\"%s\"` is not exported.", rt.Field(k).Name, name)
+		}
+
+		i, ok := b.ColumnIndex(name)
+		if ok == false {
+			return fmt.Errorf("scan: field %s: column \"%s\" not found or ambiguous.", rt.Field(k).Name, name)
+		}
+
+		field := rv.Field(k)
+
+		if field.Kind() == reflect.Ptr {
+			if b.ColIsNull(i) {
+				field.Set(reflect.Zero(field.Type()))
+				continue
+			}
+
+			if field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+
+			field = field.Elem()
+		}
+
+		if err := b.scanColumn(true, i, field.Addr().Interface()); err != nil {
+			return fmt.Errorf("scan: field %s: %s", rt.Field(k).Name, strings.TrimPrefix(err.Error(), "scan: "))
 		}
 	}
 
@@ -632,3 +1809,86 @@ func (b *Batch) Scan(dest ...interface{}) error {
 
 
 
+
+// SliceScan returns the columns of the current record as a slice of values, as returned by ColValue.
+// NULL columns are returned as nil.
+//
+// It is provided for easy porting of code written for sqlx.
+//
+func (b *Batch) SliceScan() ([]interface{}, error) {
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if b.status != sTATUS_RECORD_AVAILABLE {
+		return nil, fmt.Errorf("scan: record not available.")
+	}
+
+	res := make([]interface{}, b.ColCount())
+
+	for i := range res {
+		res[i], _ = b.ColValue(i)
+	}
+
+	return res, nil
+}
+
+// RowsSlice returns an iterator on the remaining records of the current recordset. For each record, it yields a new slice of values, as returned by SliceScan.
+//
+//	for row := range b.RowsSlice() {
+//		fmt.Println(row...)
+//	}
+//
+//	if b.Err() != nil {
+//		log.Fatalf("%s", b.Err())
+//	}
+//
+// The records are read from the server as the loop runs, so that large recordsets are not buffered in memory. A new slice is allocated for each record, and can be kept by the caller.
+//
+// Errors are not yielded. The loop just stops, and the error is returned by b.Err().
+// If the loop is exited early with break, the remaining records of the recordset are not read, and Next can be called to continue.
+//
+func (b *Batch) RowsSlice() iter.Seq[[]interface{}] {
+
+	return func(yield func([]interface{}) bool) {
+		for b.Next() {
+			row, err := b.SliceScan()
+			if err != nil { // never happens, as a record is available
+				return
+			}
+
+			if yield(row) == false {
+				return
+			}
+		}
+	}
+}
+
+// MapScan fills dest with the columns of the current record. The keys are the column names, and the values are the same as returned by ColValue.
+// NULL columns are stored as nil.
+//
+// Columns without name are skipped. If many columns have the same name, the last one is stored.
+//
+// It is provided for easy porting of code written for sqlx.
+//
+func (b *Batch) MapScan(dest map[string]interface{}) error {
+
+	if b.err != nil {
+		return b.err
+	}
+
+	if b.status != sTATUS_RECORD_AVAILABLE {
+		return fmt.Errorf("scan: record not available.")
+	}
+
+	for i, name := range b.colnameList {
+		if name == "" {
+			continue
+		}
+
+		dest[name], _ = b.ColValue(i)
+	}
+
+	return nil
+}
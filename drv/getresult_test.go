@@ -0,0 +1,441 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"rsql/rsqlib"
+)
+
+func Test_schema(t *testing.T) {
+	var (
+		err error
+		res string
+	)
+
+	b := &Batch{}
+
+	if _, err = b.Schema(); err == nil {
+		t.Fatalf("error was expected if no recordset")
+	}
+
+	b.colnameList = []string{"orderid", "odd]name", "", "price"}
+	b.record = []rsqlib.IField{
+		&rsqlib.Int{},
+		&rsqlib.Varchar{Precision: 5, Fixlen: true},
+		&rsqlib.Varchar{Precision: 100},
+		&rsqlib.Numeric{Precision: 12, Scale: 2},
+	}
+
+	expected := "(\n    [orderid] INT,\n    [odd]]name] CHAR(5),\n    [col3] VARCHAR(100),\n    [price] NUMERIC(12,2)\n)"
+
+	if res, err = b.Schema(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if res != expected {
+		t.Fatalf("result\n%s\n!=\n%s", res, expected)
+	}
+}
+
+func Test_col_datetime_in(t *testing.T) {
+	var (
+		err error
+		val time.Time
+	)
+
+	loc := time.FixedZone("UTC+5", 5*3600)
+
+	b := &Batch{}
+	b.record = []rsqlib.IField{
+		&rsqlib.Datetime{Val: time.Date(2017, time.March, 4, 13, 30, 0, 0, time.UTC)},
+		&rsqlib.Time{Val: time.Date(1900, time.January, 1, 13, 30, 0, 0, time.UTC)},
+	}
+
+	if val, _ = b.ColDatetimeIn(0, loc); val.Location() != loc || val.Hour() != 13 || val.Day() != 4 {
+		t.Fatalf("DATETIME should keep its wall clock in loc, got %s", val)
+	}
+
+	if val, _ = b.ColDatetimeIn(1, loc); val.Location() != time.UTC || val.Hour() != 13 {
+		t.Fatalf("TIME should stay in UTC, got %s", val)
+	}
+
+	if _, _, err = b.TryColDatetimeIn(0, nil); err == nil {
+		t.Fatalf("error was expected for nil location")
+	}
+}
+
+func Test_set_time_layouts(t *testing.T) {
+
+	b := &Batch{}
+	b.record = []rsqlib.IField{
+		&rsqlib.Date{Val: time.Date(2017, time.March, 4, 0, 0, 0, 0, time.UTC)},
+		&rsqlib.Time{Val: time.Date(1900, time.January, 1, 13, 30, 5, 0, time.UTC)},
+		&rsqlib.Datetime{Val: time.Date(2017, time.March, 4, 13, 30, 5, 0, time.UTC)},
+	}
+
+	check := func(expected ...string) {
+		for i, exp := range expected {
+			if s, _ := b.ColString(i); s != exp {
+				t.Fatalf("ColString(%d): %q expected, got %q", i, exp, s)
+			}
+
+			if s, _ := b.ColStringBytes(i); string(s) != exp {
+				t.Fatalf("ColStringBytes(%d): %q expected, got %q", i, exp, s)
+			}
+		}
+	}
+
+	check("2017-03-04", "13:30:05", "2017-03-04 13:30:05")
+
+	b.SetTimeLayouts("02.01.2006", "", time.RFC3339)
+	check("04.03.2017", "13:30:05", "2017-03-04T13:30:05Z")
+
+	b.SetTimeLayouts("", "3:04PM", "")
+	check("2017-03-04", "1:30PM", "2017-03-04 13:30:05")
+}
+
+func Test_scan_struct(t *testing.T) {
+	var (
+		err error
+	)
+
+	type Order struct {
+		Customer int       `rsql:"custid"`
+		Date     time.Time `rsql:"odate"`
+		Comment  *string   `rsql:"comment"`
+		Note     *string   `rsql:"note"`
+		Other    string
+	}
+
+	odate := time.Date(2017, time.March, 4, 0, 0, 0, 0, time.UTC)
+
+	b := &Batch{status: sTATUS_RECORD_AVAILABLE}
+	b.colnameList = []string{"custid", "odate", "comment", "note", "total"}
+	b.colnameMap = map[string]int{"custid": 0, "odate": 1, "comment": 2, "note": 3, "total": 4}
+	b.record = []rsqlib.IField{
+		&rsqlib.Int{Val: 1000},
+		&rsqlib.Datetime{Val: odate},
+		&rsqlib.Varchar{Val: []byte("urgent")},
+		&rsqlib.Varchar{Is_Null: true},
+		&rsqlib.Float{Val: 12.5},
+	}
+
+	note := "old"
+	order := Order{Note: &note, Other: "unchanged"}
+
+	if err = b.Scan(&order); err != nil { // single struct pointer, same as ScanStruct
+		t.Fatalf("%s", err)
+	}
+
+	if order.Customer != 1000 || order.Date.Equal(odate) == false || order.Comment == nil || *order.Comment != "urgent" || order.Note != nil || order.Other != "unchanged" {
+		t.Fatalf("bad result %+v", order)
+	}
+
+	var wrong struct {
+		Missing int `rsql:"missing"`
+	}
+
+	if err = b.ScanStruct(&wrong); err == nil {
+		t.Fatalf("error was expected for missing column")
+	}
+
+	var unexported struct {
+		Customer int       `rsql:"custid"`
+		date     time.Time `rsql:"odate"`
+	}
+
+	if err = b.ScanStruct(&unexported); err == nil || strings.Contains(err.Error(), "field date ") == false {
+		t.Fatalf("error naming the unexported field was expected, got %v", err)
+	}
+
+	b.colnameList = []string{"odate"}
+	b.colnameMap = map[string]int{"odate": 0}
+	b.record = []rsqlib.IField{&rsqlib.Datetime{Val: odate}}
+
+	var dt time.Time
+
+	if err = b.Scan(&dt); err != nil || dt.Day() != 4 { // *time.Time is scanned as a single column
+		t.Fatalf("%v %s", err, dt)
+	}
+}
+
+func Test_col_float64_lax(t *testing.T) {
+
+	b := &Batch{}
+	b.record = []rsqlib.IField{
+		&rsqlib.Float{Val: 1.5},
+		&rsqlib.Bigint{Val: -42},
+		&rsqlib.Money{Precision: 19, Scale: 4, Val: []byte("-12.75")},
+		&rsqlib.Numeric{Val: []byte("1234.5")},
+		&rsqlib.Numeric{Is_Null: true},
+		&rsqlib.Varchar{Val: []byte("1.5")},
+	}
+
+	expected := []float64{1.5, -42, -12.75, 1234.5, 0}
+
+	for i, exp := range expected {
+		val, isnull, err := b.ColFloat64Lax(i)
+		if err != nil || val != exp || isnull != (i == 4) {
+			t.Fatalf("column %d: %v %v %v", i, val, isnull, err)
+		}
+	}
+
+	if _, _, err := b.ColFloat64Lax(5); err == nil {
+		t.Fatalf("error was expected for VARCHAR column")
+	}
+}
+
+func Test_col_name(t *testing.T) {
+
+	b := &Batch{}
+
+	if b.ColName(0) != "" {
+		t.Fatalf("empty name expected if no recordset")
+	}
+
+	b.colnameList = []string{"orderid", ""}
+
+	for i, expected := range []string{"", "orderid", "", ""} {
+		if name := b.ColName(i - 1); name != expected {
+			t.Fatalf("column %d: expected %q, got %q", i-1, expected, name)
+		}
+	}
+}
+
+func Test_concurrent_use(t *testing.T) {
+
+	session := &rsqlib.Session{}
+	b := &Batch{conn: &Connection{session: session}}
+
+	if err := session.Acquire_reader(); err != nil { // simulate another goroutine reading the batch
+		t.Fatalf("%s", err)
+	}
+
+	if b.Next() {
+		t.Fatalf("Next should return false")
+	}
+
+	if ok, err := b.NextErr(); ok || err != ErrConcurrentUse {
+		t.Fatalf("false and ErrConcurrentUse expected, got %v and %v", ok, err)
+	}
+
+	if b.PeekNext() != EVENT_ERROR {
+		t.Fatalf("EVENT_ERROR expected")
+	}
+
+	if err := b.Finalize(); err != ErrConcurrentUse {
+		t.Fatalf("ErrConcurrentUse expected, got %v", err)
+	}
+
+	if b.Err() != nil { // the error belongs to the offending caller, not to the reading goroutine
+		t.Fatalf("Err should not be affected, got %v", b.Err())
+	}
+
+	session.Release_reader()
+
+	if err := session.Acquire_reader(); err != nil {
+		t.Fatalf("session should be readable after Release_reader: %s", err)
+	}
+}
+
+func Test_col_unix(t *testing.T) {
+	var (
+		err error
+	)
+
+	dt := time.Date(2017, time.March, 4, 13, 30, 5, 500, time.UTC)
+
+	b := &Batch{}
+	b.record = []rsqlib.IField{
+		&rsqlib.Date{Val: time.Date(2017, time.March, 4, 0, 0, 0, 0, time.UTC)},
+		&rsqlib.Datetime{Val: dt},
+		&rsqlib.Datetime{Is_Null: true},
+		&rsqlib.Time{Val: time.Date(1900, time.January, 1, 13, 30, 0, 0, time.UTC)},
+		&rsqlib.Datetime{Val: time.Date(1000, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if val, _ := b.ColUnixSeconds(0); val != 1488585600 {
+		t.Fatalf("DATE: %d", val)
+	}
+
+	if val, _ := b.ColUnixSeconds(1); val != dt.Unix() {
+		t.Fatalf("DATETIME: %d", val)
+	}
+
+	if val, _ := b.ColUnixNano(1); val != dt.UnixNano() {
+		t.Fatalf("DATETIME: %d", val)
+	}
+
+	if val, isnull := b.ColUnixNano(2); val != 0 || isnull == false {
+		t.Fatalf("NULL expected")
+	}
+
+	if _, _, err = b.TryColUnixSeconds(3); err == nil {
+		t.Fatalf("error expected for TIME column")
+	}
+
+	if val, _ := b.ColUnixSeconds(4); val != time.Date(1000, time.January, 1, 0, 0, 0, 0, time.UTC).Unix() {
+		t.Fatalf("year 1000: %d", val)
+	}
+
+	if _, _, err = b.TryColUnixNano(4); err == nil {
+		t.Fatalf("error expected for year 1000 in nanoseconds")
+	}
+}
+
+func Test_set_time_location(t *testing.T) {
+	var (
+		dest time.Time
+	)
+
+	loc := time.FixedZone("UTC+5", 5*3600)
+
+	b := &Batch{conn: &Connection{}}
+	b.record = []rsqlib.IField{
+		&rsqlib.Datetime{Val: time.Date(2017, time.March, 4, 13, 30, 0, 0, time.UTC)},
+		&rsqlib.Time{Val: time.Date(1900, time.January, 1, 13, 30, 0, 0, time.UTC)},
+	}
+
+	if val, _ := b.ColValue(0); val.(time.Time).Location() != time.Local {
+		t.Fatalf("time.Local expected by default")
+	}
+
+	b.conn.SetTimeLocation(loc)
+
+	if val, _ := b.ColValue(0); val.(time.Time).Location() != loc || val.(time.Time).Hour() != 13 {
+		t.Fatalf("ColValue: DATETIME should keep its wall clock in loc, got %s", val)
+	}
+
+	if err := b.scanColumn(false, 0, &dest); err != nil || dest.Location() != loc || dest.Hour() != 13 {
+		t.Fatalf("Scan: DATETIME should keep its wall clock in loc, got %s %v", dest, err)
+	}
+
+	if val, _ := b.ColValue(1); val.(time.Time).Location() != time.UTC {
+		t.Fatalf("TIME should stay in UTC")
+	}
+
+	if val, _ := b.ColDatetimeUTC(0); val.Location() != time.UTC {
+		t.Fatalf("ColDatetimeUTC should not be affected")
+	}
+}
+
+func Test_col_binary_into(t *testing.T) {
+	var (
+		err error
+		val []byte
+	)
+
+	field := &rsqlib.Varbinary{Val: []byte{1, 2, 3}}
+
+	b := &Batch{}
+	b.record = []rsqlib.IField{
+		field,
+		&rsqlib.Varbinary{Is_Null: true},
+		&rsqlib.Int{Val: 1},
+	}
+
+	buff := make([]byte, 2, 16)
+
+	val, isnull := b.ColBinaryInto(0, buff)
+	if isnull || bytes.Equal(val, []byte{1, 2, 3}) == false {
+		t.Fatalf("bad value %v", val)
+	}
+
+	if &val[0] != &buff[:1][0] {
+		t.Fatalf("backing array of dst should be reused")
+	}
+
+	field.Val[0] = 9 // next record modifies the field buffer
+
+	if val[0] != 1 {
+		t.Fatalf("returned slice should not alias the field buffer")
+	}
+
+	if val, isnull = b.ColBinaryInto(1, buff); isnull == false || val == nil || len(val) != 0 {
+		t.Fatalf("NULL expected, got %v %v", val, isnull)
+	}
+
+	if _, _, err = b.TryColBinaryInto(2, buff); err == nil {
+		t.Fatalf("error expected for INT column")
+	}
+}
+
+func Test_reject_non_finite(t *testing.T) {
+	var (
+		err  error
+		dest float64
+	)
+
+	b := &Batch{}
+	b.record = []rsqlib.IField{
+		&rsqlib.Float{Val: math.NaN()},
+		&rsqlib.Float{Val: math.Inf(-1)},
+		&rsqlib.Float{Val: 1.5},
+	}
+
+	if val, _ := b.ColFloat64(0); math.IsNaN(val) == false {
+		t.Fatalf("NaN should be returned by default")
+	}
+
+	b.SetRejectNonFinite(true)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err = b.TryColFloat64(i); err == nil {
+			t.Fatalf("column %d: error expected", i)
+		}
+
+		if _, _, err = b.ColFloat64Lax(i); err == nil {
+			t.Fatalf("column %d: ColFloat64Lax error expected", i)
+		}
+
+		if err = b.scanColumn(false, i, &dest); err == nil {
+			t.Fatalf("column %d: scan error expected", i)
+		}
+	}
+
+	if val, _ := b.ColFloat64(2); val != 1.5 {
+		t.Fatalf("1.5 expected, got %v", val)
+	}
+}
+
+func Test_col_bool_boolean(t *testing.T) {
+	var dest [2]bool
+
+	b := &Batch{status: sTATUS_RECORD_AVAILABLE}
+	b.record = []rsqlib.IField{
+		&rsqlib.Boolean{Val: true},
+		&rsqlib.Boolean{Is_Null: true},
+	}
+
+	if b.ColDatatype(0) != BOOLEAN || b.ColDatatype(0).String() != "BOOLEAN" {
+		t.Fatalf("BOOLEAN datatype expected")
+	}
+
+	if val, isnull := b.ColBool(0); val != true || isnull {
+		t.Fatalf("true expected")
+	}
+
+	if val, isnull := b.ColBool(1); val != false || isnull == false {
+		t.Fatalf("NULL expected")
+	}
+
+	if val, _ := b.ColValue(0); val != true {
+		t.Fatalf("ColValue: true expected, got %v", val)
+	}
+
+	dest[1] = true
+	if err := b.Scan(&dest[0], &dest[1]); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if dest != [2]bool{true, false} {
+		t.Fatalf("bad scan %v", dest)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"fmt"
+	"strings"
+
+	"rsql/rsqlib"
+)
+
+// SplitBatch splits a SQL script into chunks which are smaller than maxSize bytes, so that each chunk can be sent by Execute as a separate batch.
+//
+// The script is only split at statement boundaries, which are:
+//
+//    - a semicolon, which remains at the end of its statement.
+//    - a line containing only GO (case insensitive), as in scripts for MS SQL Server. The GO line is removed, and the next statement always starts a new chunk.
+//
+// Semicolons and GO inside string literals '...', bracketed identifiers [...] and comments (-- ... and /* ... */, which can be nested) are ignored.
+// Semicolons inside BEGIN ... END blocks and CASE ... END expressions are also ignored, so that a block is never split. BEGIN TRAN, BEGIN TRANSACTION and BEGIN DISTRIBUTED don't start a block.
+// A GO line always splits the script, even inside a block.
+// Consecutive statements are put in the same chunk as long as it remains smaller than maxSize. Statements are trimmed, and empty statements (blank or a lone semicolon) are omitted.
+//
+// If maxSize <= 0 or > rsqlib.BATCH_TEXT_SIZE_MAX, rsqlib.BATCH_TEXT_SIZE_MAX is used.
+// An error is returned if a single statement is not smaller than maxSize.
+//
+//    if chunks, err = drv.SplitBatch(script, 0); err != nil {
+//        log.Fatalf("%s", err)
+//    }
+//
+//    for _, chunk := range chunks {
+//        if _, err = conn.Execute(chunk); err != nil {
+//            log.Fatalf("%s", err)
+//        }
+//    }
+//
+// Note that variables declared in a chunk are not visible in the next chunks, as each chunk is a separate batch.
+//
+func SplitBatch(text string, maxSize int) ([]string, error) {
+	type statement struct {
+		text     string
+		lineNo   int  // line of the start of the statement, for error message
+		newChunk bool // a GO line precedes the statement
+	}
+
+	var (
+		statements  []statement
+		depth       int // nesting level of BEGIN ... END and CASE ... END
+		start       int // start of current statement
+		startLineNo int
+		newChunk    bool
+		chunks      []string
+		chunk       string
+	)
+
+	if maxSize <= 0 || maxSize > rsqlib.BATCH_TEXT_SIZE_MAX {
+		maxSize = rsqlib.BATCH_TEXT_SIZE_MAX
+	}
+
+	// split text into statements
+
+	addStatement := func(end int) {
+		s := strings.TrimRight(text[start:end], " \t\r\n")
+		trimmed := strings.TrimLeft(s, " \t\r\n")
+
+		if trimmed != "" && trimmed != ";" {
+			line := startLineNo + strings.Count(s[:len(s)-len(trimmed)], "\n") // skip leading empty lines
+			statements = append(statements, statement{text: trimmed, lineNo: line, newChunk: newChunk})
+			newChunk = false
+		}
+	}
+
+	sc := newSQLScanner(text)
+	startLineNo = 1
+
+	for sc.pos < len(text) {
+		i := sc.pos
+
+		if i == 0 || text[i-1] == '\n' { // start of line, check for GO
+			lineEnd := strings.IndexByte(text[i:], '\n')
+			if lineEnd == -1 {
+				lineEnd = len(text) - i
+			}
+
+			if strings.EqualFold(strings.TrimSpace(text[i:i+lineEnd]), "GO") {
+				addStatement(i)
+				newChunk = true
+				depth = 0
+
+				sc.advance(lineEnd)
+				start = sc.pos
+				startLineNo = sc.lineNo
+				continue
+			}
+		}
+
+		if sc.skipOpaque() { // string literal, bracketed identifier or comment
+			continue
+		}
+
+		if word := sc.word(); word != "" {
+			switch {
+			case strings.EqualFold(word, "BEGIN"):
+				next := wordAt(strings.TrimLeft(text[i+len(word):], " \t\r\n"), 0)
+				if strings.EqualFold(next, "TRAN") == false && strings.EqualFold(next, "TRANSACTION") == false && strings.EqualFold(next, "DISTRIBUTED") == false {
+					depth++
+				}
+
+			case strings.EqualFold(word, "CASE"):
+				depth++
+
+			case strings.EqualFold(word, "END"):
+				if depth > 0 { // an unbalanced END is reported by the server
+					depth--
+				}
+			}
+
+			sc.advance(len(word))
+			continue
+		}
+
+		if text[i] == ';' && depth == 0 {
+			addStatement(i + 1)
+			start = i + 1
+			startLineNo = sc.lineNo
+		}
+
+		sc.advance(1)
+	}
+
+	addStatement(len(text)) // unterminated string literal or comment is not checked, the server will report it
+
+	// put statements into chunks
+
+	for _, stmt := range statements {
+		if len(stmt.text) >= maxSize {
+			return nil, fmt.Errorf("SplitBatch: statement at line %d is too large, chunk must be < %d bytes.", stmt.lineNo, maxSize)
+		}
+
+		if chunk != "" && (stmt.newChunk || len(chunk)+1+len(stmt.text) >= maxSize) { // 1 for "\n"
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+
+		if chunk == "" {
+			chunk = stmt.text
+		} else {
+			chunk += "\n" + stmt.text
+		}
+	}
+
+	if chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_split_batch(t *testing.T) {
+	var (
+		err    error
+		chunks []string
+	)
+
+	script := `
+INSERT INTO t VALUES (1, 'a;b');
+-- comment; with semicolon
+INSERT INTO t VALUES (2, 'it''s; ok'); /* block ; /* nested ; */ still ; */
+go
+PRINT 'GO';
+  GO  
+SELECT 1;
+SELECT 2`
+
+	if chunks, err = SplitBatch(script, 0); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := []string{
+		"INSERT INTO t VALUES (1, 'a;b');\n-- comment; with semicolon\nINSERT INTO t VALUES (2, 'it''s; ok');\n/* block ; /* nested ; */ still ; */",
+		"PRINT 'GO';",
+		"SELECT 1;\nSELECT 2",
+	}
+
+	if reflect.DeepEqual(chunks, expected) == false {
+		t.Fatalf("%q expected, got %q", expected, chunks)
+	}
+
+	// chunks limited by size
+
+	if chunks, err = SplitBatch("SELECT 1; SELECT 2; SELECT 3;", 20); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected = []string{"SELECT 1;\nSELECT 2;", "SELECT 3;"}
+
+	if reflect.DeepEqual(chunks, expected) == false {
+		t.Fatalf("%q expected, got %q", expected, chunks)
+	}
+
+	for _, chunk := range chunks {
+		if len(chunk) >= 20 {
+			t.Fatalf("chunk %q too large", chunk)
+		}
+	}
+
+	// statement too large
+
+	if _, err = SplitBatch("SELECT 1;\n\nSELECT 'long string';", 15); err == nil || strings.Contains(err.Error(), "line 3") == false {
+		t.Fatalf("error at line 3 expected, got %v", err)
+	}
+
+	if chunks, err = SplitBatch(" \n GO \n ; ", 0); err != nil || len(chunks) != 0 {
+		t.Fatalf("no chunk expected, got %q %v", chunks, err)
+	}
+}
+
+func Test_split_batch_blocks(t *testing.T) {
+
+	tests := []struct {
+		script   string
+		expected []string
+	}{
+		{"SELECT [it's]; SELECT 'x;y'", []string{"SELECT [it's];\nSELECT 'x;y'"}},                                         // bracketed identifier doesn't start a string literal
+		{"SELECT [a;b]; SELECT 2;\nGO\nSELECT 3", []string{"SELECT [a;b];\nSELECT 2;", "SELECT 3"}},                       // semicolon inside brackets
+		{"IF 1=1 BEGIN SELECT 1; SELECT 2; END; SELECT 3;", []string{"IF 1=1 BEGIN SELECT 1; SELECT 2; END;\nSELECT 3;"}}, // semicolons inside and outside BEGIN ... END
+		{"begin\n  begin select 1; end;\n  select 2;\nend;", []string{"begin\n  begin select 1; end;\n  select 2;\nend;"}},
+		{"SELECT CASE WHEN a=1 THEN 'x' END; SELECT 2;", []string{"SELECT CASE WHEN a=1 THEN 'x' END;\nSELECT 2;"}},
+		{"BEGIN TRAN; INSERT INTO t VALUES (1); COMMIT;", []string{"BEGIN TRAN;\nINSERT INTO t VALUES (1);\nCOMMIT;"}},        // not a block
+		{"SELECT beginning, ending, [end] FROM t; SELECT 2;", []string{"SELECT beginning, ending, [end] FROM t;\nSELECT 2;"}}, // whole words only
+		{"END; SELECT 1;", []string{"END;\nSELECT 1;"}},                                                                       // depth is clamped at 0
+		{"BEGIN SELECT 1;\ngo\nSELECT 2; SELECT 3;", []string{"BEGIN SELECT 1;", "SELECT 2;\nSELECT 3;"}},                     // GO always splits
+	}
+
+	for _, tt := range tests {
+		chunks, err := SplitBatch(tt.script, 0)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.script, err)
+		}
+
+		if reflect.DeepEqual(chunks, tt.expected) == false {
+			t.Fatalf("%q: %q expected, got %q", tt.script, tt.expected, chunks)
+		}
+	}
+
+	// a statement inside a block is not split at its semicolons, even if the chunk is too large
+
+	if _, err := SplitBatch("BEGIN SELECT 1; SELECT 2; END;", 20); err == nil {
+		t.Fatalf("error expected, the block cannot be split")
+	}
+}
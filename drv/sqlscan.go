@@ -0,0 +1,138 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package drv
+
+import (
+	"strings"
+)
+
+// sqlScanner scans a SQL text byte by byte. It is used by ParseTemplate and SplitBatch.
+//
+// It recognizes the parts of the text which are not SQL code, and in which placeholder delimiters, semicolons, etc are just text:
+//
+//    - string literals '...', in which a quote is doubled.
+//    - bracketed identifiers [...], in which ] is doubled. So, [it's] doesn't start a string literal.
+//    - comments -- ... until the end of the line, and /* ... */, which can be nested.
+//
+// An unterminated string literal, identifier or comment extends to the end of the text. It is not an error, the server will report it.
+//
+type sqlScanner struct {
+	text   string
+	pos    int // position of the next byte to scan
+	lineNo int // line of pos, starting at 1
+}
+
+func newSQLScanner(text string) *sqlScanner {
+
+	return &sqlScanner{text: text, lineNo: 1}
+}
+
+// advance moves the position n bytes forward, counting the lines.
+//
+func (sc *sqlScanner) advance(n int) {
+
+	sc.lineNo += strings.Count(sc.text[sc.pos:sc.pos+n], "\n")
+	sc.pos += n
+}
+
+// skipOpaque skips the string literal, bracketed identifier or comment which starts at the current position, and returns true.
+// If none starts at this position, it returns false and the position is not changed.
+//
+func (sc *sqlScanner) skipOpaque() bool {
+	var end int
+
+	text := sc.text
+	i := sc.pos
+
+	switch {
+	case text[i] == '\'':
+		end = skipQuoted(text, i, '\'')
+
+	case text[i] == '[':
+		end = skipQuoted(text, i, ']')
+
+	case strings.HasPrefix(text[i:], "--"):
+		end = strings.IndexByte(text[i:], '\n') // the newline is not part of the comment
+		if end == -1 {
+			end = len(text)
+		} else {
+			end += i
+		}
+
+	case strings.HasPrefix(text[i:], "/*"):
+		depth := 0
+		end = len(text)
+
+		for k := i; k < len(text); k++ {
+			if strings.HasPrefix(text[k:], "/*") {
+				depth++
+				k++
+			} else if strings.HasPrefix(text[k:], "*/") {
+				depth--
+				k++
+				if depth == 0 {
+					end = k + 1
+					break
+				}
+			}
+		}
+
+	default:
+		return false
+	}
+
+	sc.advance(end - i)
+
+	return true
+}
+
+// word returns the identifier or keyword starting at the current position, or "" if the current byte cannot be part of a word.
+//
+func (sc *sqlScanner) word() string {
+
+	return wordAt(sc.text, sc.pos)
+}
+
+// wordAt returns the identifier or keyword starting at position i of text, or "" if none.
+//
+func wordAt(text string, i int) string {
+
+	k := i
+	for k < len(text) && isWordByte(text[k]) {
+		k++
+	}
+
+	return text[i:k]
+}
+
+// isWordByte returns true if c can be part of an identifier or keyword.
+//
+func isWordByte(c byte) bool {
+
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '@' || c == '#' || c == '$' || c >= 0x80
+}
+
+// skipQuoted returns the position after the closing character of the string literal or bracketed identifier starting at position i.
+// Inside, a doubled closing character stands for itself.
+//
+func skipQuoted(text string, i int, closing byte) int {
+
+	k := i + 1
+
+	for {
+		end := strings.IndexByte(text[k:], closing)
+		if end == -1 {
+			return len(text)
+		}
+
+		k += end + 1
+
+		if k < len(text) && text[k] == closing { // doubled
+			k++
+			continue
+		}
+
+		return k
+	}
+}
@@ -5,6 +5,7 @@ package drv
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -123,8 +124,12 @@ func (part *SQLpart) Err() error {
 //
 // By default, placeholder delimiters are {{ and }}, but you can pass other opening and closing delimiters as two optional arguments.
 //
+// Delimiters inside string literals '...', bracketed identifiers [...] and comments -- ... or /* ... */ are just text, and are not placeholders. So, a placeholder cannot be put inside a string literal, which is not needed as BindStr adds the quotes, nor inside brackets, which BindIdentifier adds.
+//
 // If incorrect syntax is found with placeholder or delimiters in text argument (e.g. missing closing delimiter), the function panics.
 //
+// NewSQLpart parses the text each time it is called. If the same text is used many times, e.g. in a hot path, you should parse it once with ParseTemplate, and create SQLpart objects with the New method of the ParsedTemplate.
+//
 // Example:
 //
 //    p := drv.NewSQLpart("INSERT INTO mydb..parents (firstName, lastName) VALUES ({{fname}}, {{lname}});")
@@ -136,6 +141,37 @@ func (part *SQLpart) Err() error {
 //    fmt.Println(s)  // prints     INSERT INTO mydb..parents (firstName, lastName) VALUES ('John', 'O''Hara');
 //
 func NewSQLpart(text string, placeholderDelimiters ...string) *SQLpart {
+
+	return ParseTemplate(text, placeholderDelimiters...).New()
+}
+
+// ParsedTemplate is the immutable result of parsing the SQL text of a SQLpart.
+// It is created once by ParseTemplate, and can then be used to cheaply create many SQLpart objects with the New method.
+//
+// A ParsedTemplate is never modified, and can be used concurrently by multiple goroutines.
+//
+type ParsedTemplate struct {
+	text           string           // original SQL text
+	textFragments  []interface{}    // string for sql text parts, and nil for placeholders
+	placeholderMap map[string][]int // for each placeholder, value is the list of indices in textFragments slice referencing the placeholder name
+}
+
+// ParseTemplate parses the specified SQL text, which can contain named placeholders, and returns a ParsedTemplate.
+//
+// The syntax of the text and placeholders is the same as for NewSQLpart.
+//
+// If incorrect syntax is found with placeholder or delimiters in text argument (e.g. missing closing delimiter), the function panics.
+//
+// Example:
+//
+//    var insertTemplate = drv.ParseTemplate("INSERT INTO mydb..parents (firstName, lastName) VALUES ({{fname}}, {{lname}});") // parsed only once
+//
+//    ...
+//
+//    p := insertTemplate.New()
+//    p.BindStr("fname", "John").BindStr("lname", "O'Hara")
+//
+func ParseTemplate(text string, placeholderDelimiters ...string) *ParsedTemplate {
 	type State uint8
 
 	const (
@@ -149,9 +185,8 @@ func NewSQLpart(text string, placeholderDelimiters ...string) *SQLpart {
 		delimRight       string = "}}"
 		delimRightLength int
 
-		sqlpart           *SQLpart
+		template          *ParsedTemplate
 		textLength        int
-		lineNo            int
 		textFragmentStart int
 		placeholderStart  int
 		state             State
@@ -159,7 +194,7 @@ func NewSQLpart(text string, placeholderDelimiters ...string) *SQLpart {
 		placeholderMap    map[string][]int // for each placeholder, value is the list of indices in textFragments slice referencing the placeholder name
 	)
 
-	sqlpart = &SQLpart{}
+	template = &ParsedTemplate{}
 
 	// define delimiters for placeholders
 
@@ -189,19 +224,21 @@ func NewSQLpart(text string, placeholderDelimiters ...string) *SQLpart {
 
 	// parse the sql text and split it at placeholder positions
 
-	sqlpart.text = text
+	template.text = text
 
 	textLength = len(text)
 	textFragmentStart = 0
 	placeholderStart = -1
 	state = StateText
-	lineNo = 1
 
-	i := 0
-	for i < textLength {
+	sc := newSQLScanner(text)
+
+	for sc.pos < textLength {
+		i := sc.pos
+
 		if i+delimLeftLength <= textLength && text[i:i+delimLeftLength] == delimLeft {
 			if state != StateText {
-				panic(fmt.Sprintf("SQLpart: invalid opening delimiter for placeholder (line %d).", lineNo))
+				panic(fmt.Sprintf("SQLpart: invalid opening delimiter for placeholder (line %d).", sc.lineNo))
 			}
 			state = StatePlaceholder
 
@@ -209,23 +246,23 @@ func NewSQLpart(text string, placeholderDelimiters ...string) *SQLpart {
 				textFragments = append(textFragments, text[textFragmentStart:i])
 			}
 
-			i += delimLeftLength
+			sc.advance(delimLeftLength)
 			textFragmentStart = -1
-			placeholderStart = i
+			placeholderStart = sc.pos
 
 			continue
 		}
 
 		if i+delimRightLength <= textLength && text[i:i+delimRightLength] == delimRight {
 			if state != StatePlaceholder {
-				panic(fmt.Sprintf("SQLpart: invalid terminating delimiter for placeholder (line %d).", lineNo))
+				panic(fmt.Sprintf("SQLpart: invalid terminating delimiter for placeholder (line %d).", sc.lineNo))
 			}
 
 			placeholderEndx := i
 			placeholderName := strings.TrimSpace(strings.ToLower(text[placeholderStart:placeholderEndx]))
 
 			if len(placeholderName) == 0 {
-				panic(fmt.Sprintf("SQLpart: placeholder name cannot be empty (line %d).", lineNo))
+				panic(fmt.Sprintf("SQLpart: placeholder name cannot be empty (line %d).", sc.lineNo))
 			}
 
 			textFragments = append(textFragments, nil) // the Bindxxx functions will replace these strings by parameter values
@@ -237,25 +274,28 @@ func NewSQLpart(text string, placeholderDelimiters ...string) *SQLpart {
 			pos := len(textFragments) - 1
 			placeholderMap[placeholderName] = append(placeholderMap[placeholderName], pos)
 
-			i += delimRightLength
-			textFragmentStart = i
+			sc.advance(delimRightLength)
+			textFragmentStart = sc.pos
 			state = StateText
 
 			continue
 		}
 
-		if text[i] == '\n' {
-			if state == StatePlaceholder {
-				panic(fmt.Sprintf("SQLpart: placeholder closing delimiter not found (line %d).", lineNo))
+		if state == StatePlaceholder {
+			if text[i] == '\n' {
+				panic(fmt.Sprintf("SQLpart: placeholder closing delimiter not found (line %d).", sc.lineNo))
 			}
-			lineNo++
+		} else if sc.skipOpaque() { // in string literal, bracketed identifier or comment, delimiters are just text
+			continue
 		}
 
-		i++
+		sc.advance(1)
 	}
 
-	if state != StateText {
-		panic(fmt.Sprintf("SQLpart: terminating delimiter expected for placeholder (line %d).", lineNo))
+	i := sc.pos
+
+	if state == StatePlaceholder { // unterminated string literal or comment is not checked, the server will report it
+		panic(fmt.Sprintf("SQLpart: terminating delimiter expected for placeholder (line %d).", sc.lineNo))
 	}
 
 	if textFragmentStart != i {
@@ -273,8 +313,22 @@ func NewSQLpart(text string, placeholderDelimiters ...string) *SQLpart {
 		}
 	}
 
-	sqlpart.textFragments = textFragments
-	sqlpart.placeholderMap = placeholderMap
+	template.textFragments = textFragments
+	template.placeholderMap = placeholderMap
+
+	return template
+}
+
+// New returns a new SQLpart object, whose placeholders can be filled by BindStr, BindInt, etc methods.
+//
+func (template *ParsedTemplate) New() *SQLpart {
+
+	sqlpart := &SQLpart{}
+
+	sqlpart.text = template.text
+	sqlpart.textFragments = make([]interface{}, len(template.textFragments)) // each SQLpart has its own copy, as Bind methods replace placeholders in it
+	copy(sqlpart.textFragments, template.textFragments)
+	sqlpart.placeholderMap = template.placeholderMap // never modified, can be shared
 
 	return sqlpart
 }
@@ -310,3 +364,36 @@ func (part *SQLpart) Text() (string, error) {
 
 	return string(buff), nil
 }
+
+// ParamNames returns the distinct placeholder names of the SQL text, sorted. As placeholder names are case insensitive, they are returned in lower case.
+//
+// It is useful to check that a template uses exactly the expected parameters, before calling the Bind methods.
+// The names are returned whether they have been bound or not.
+//
+func (part *SQLpart) ParamNames() []string {
+
+	names := make([]string, 0, len(part.placeholderMap))
+
+	for name := range part.placeholderMap {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// MustText is the same as Text, but panics if an error occurs, like regexp.MustCompile.
+//
+// It is intended for tests and scripts, where a placeholder not filled by a Bind method is a programming error.
+// In production code, use Text and check the error.
+//
+func (part *SQLpart) MustText() string {
+
+	text, err := part.Text()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return text
+}
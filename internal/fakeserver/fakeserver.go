@@ -0,0 +1,483 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+// Package fakeserver implements the server side of the RSQL communication protocol, for the tested paths.
+// It allows to test the drv and rsqlib packages without a running RSQL server.
+//
+// The fake server is connected to the client by a net.Pipe. It sends scripted responses to the batches it receives:
+//
+//    client, srv := fakeserver.New()
+//    defer srv.Close()
+//
+//    go srv.Serve(
+//        []fakeserver.Response{ // responses to the first batch
+//            fakeserver.Recordset([]fakeserver.Column{{Name: "id", Datatype: rsqlib.DTYPE_INT}}, []interface{}{1}, []interface{}{2}),
+//            fakeserver.BatchEnd(0),
+//        },
+//    )
+//
+//    conn, err := drv.NewConnectionWithConn(client, "login=sa;password=x;keepalive=0")
+//
+// The responses are not checked against the batch text. It is the responsibility of the test to script a sequence that makes sense.
+//
+package fakeserver
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"rsql/msgp"
+	"rsql/rsqlib"
+)
+
+// Column describes a column of a recordset.
+//
+type Column struct {
+	Name      string
+	Datatype  rsqlib.Dtype_t
+	Precision uint16 // for VARBINARY, VARCHAR, MONEY and NUMERIC
+	Scale     uint16 // for MONEY and NUMERIC
+	Fixlen    bool   // for VARCHAR, true for a CHAR column
+}
+
+// Response is a message sent by the server, created by Recordset, BatchEnd, etc.
+//
+type Response func(srv *Server) error
+
+// Server is the server side of a connection.
+//
+type Server struct {
+	conn net.Conn
+	mr   *msgp.Reader
+	mw   *msgp.Writer
+
+	layout []Column // columns of the current recordset, used to encode the values of Record
+}
+
+// New returns the client side of a net.Pipe, to pass to drv.NewConnectionWithConn or rsqlib.ConnectWithConn, and the fake server at the other side.
+//
+func New() (net.Conn, *Server) {
+
+	client, server := net.Pipe()
+
+	return client, NewServer(server)
+}
+
+// NewServer returns a fake server on the server side conn of a connection, e.g. accepted by a net.Listener, to test code that dials the server.
+//
+func NewServer(conn net.Conn) *Server {
+
+	return &Server{
+		conn: conn,
+		mr:   msgp.NewReader(conn),
+		mw:   msgp.NewWriter(conn),
+	}
+}
+
+// Close closes the server side of the connection. The client receives io.EOF.
+//
+func (srv *Server) Close() error {
+
+	return srv.conn.Close()
+}
+
+// Login reads the authentication request of the client, and accepts it. It returns the fields of the request, e.g. "login_name".
+//
+func (srv *Server) Login() (map[string]interface{}, error) {
+
+	auth, err := srv.readAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	srv.mw.WriteUint8(uint8(rsqlib.RESTYP_LOGIN_SUCCESS))
+
+	if err = srv.mw.Flush(); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// RejectLogin reads the authentication request of the client, and closes the connection, as the RSQL server does when login fails.
+//
+func (srv *Server) RejectLogin() error {
+
+	if _, err := srv.readAuth(); err != nil {
+		return err
+	}
+
+	return srv.conn.Close()
+}
+
+// FailLogin reads the authentication request of the client, sends RESTYP_LOGIN_FAILED, and closes the connection.
+//
+func (srv *Server) FailLogin() error {
+
+	if _, err := srv.readAuth(); err != nil {
+		return err
+	}
+
+	srv.mw.WriteUint8(uint8(rsqlib.RESTYP_LOGIN_FAILED))
+
+	if err := srv.mw.Flush(); err != nil {
+		return err
+	}
+
+	return srv.conn.Close()
+}
+
+// readAuth reads the authentication request.
+//
+func (srv *Server) readAuth() (map[string]interface{}, error) {
+
+	u, err := srv.mr.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+
+	if rsqlib.Request_t(u) != rsqlib.REQTYP_AUTH {
+		return nil, fmt.Errorf("fakeserver: request type %d received, REQTYP_AUTH expected", u)
+	}
+
+	return srv.mr.ReadMapStrIntf()
+}
+
+// ReadBatch reads the next batch sent by the client, and returns its text. Keepalive messages are skipped.
+//
+func (srv *Server) ReadBatch() (string, error) {
+
+	for {
+		u, err := srv.mr.ReadUint8()
+		if err != nil {
+			return "", err
+		}
+
+		switch rsqlib.Request_t(u) {
+		case rsqlib.REQTYP_KEEPALIVE:
+			continue
+
+		case rsqlib.REQTYP_BATCH:
+			return srv.mr.ReadString()
+
+		default:
+			return "", fmt.Errorf("fakeserver: unexpected request type %d", u)
+		}
+	}
+}
+
+// Send sends the responses to the client.
+//
+func (srv *Server) Send(responses ...Response) error {
+
+	for _, response := range responses {
+		if err := response(srv); err != nil {
+			return err
+		}
+	}
+
+	return srv.mw.Flush()
+}
+
+// Serve accepts the login, and then sends each script as the responses to a batch, after having read this batch.
+// It returns when all scripts have been sent, without closing the connection.
+//
+func (srv *Server) Serve(scripts ...[]Response) error {
+
+	if _, err := srv.Login(); err != nil {
+		return err
+	}
+
+	for _, script := range scripts {
+		if _, err := srv.ReadBatch(); err != nil {
+			return err
+		}
+
+		if err := srv.Send(script...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//======================= responses ================================
+
+// Layout sends RESTYP_RECORD_LAYOUT, which starts a recordset with the specified columns.
+//
+func Layout(columns ...Column) Response {
+
+	return func(srv *Server) error {
+		srv.layout = columns
+
+		srv.mw.WriteUint8(uint8(rsqlib.RESTYP_RECORD_LAYOUT))
+
+		srv.mw.WriteArrayHeader(uint32(len(columns)))
+		for _, col := range columns {
+			srv.mw.WriteString(col.Name)
+		}
+
+		srv.mw.WriteArrayHeader(uint32(len(columns)))
+		for _, col := range columns {
+			switch col.Datatype {
+			case rsqlib.DTYPE_VARBINARY:
+				srv.mw.WriteArrayHeader(2)
+				srv.mw.WriteUint8(uint8(col.Datatype))
+				srv.mw.WriteUint16(col.Precision)
+
+			case rsqlib.DTYPE_VARCHAR:
+				srv.mw.WriteArrayHeader(3)
+				srv.mw.WriteUint8(uint8(col.Datatype))
+				srv.mw.WriteUint16(col.Precision)
+				srv.mw.WriteBool(col.Fixlen)
+
+			case rsqlib.DTYPE_MONEY, rsqlib.DTYPE_NUMERIC:
+				srv.mw.WriteArrayHeader(3)
+				srv.mw.WriteUint8(uint8(col.Datatype))
+				srv.mw.WriteUint16(col.Precision)
+				srv.mw.WriteUint16(col.Scale)
+
+			default:
+				srv.mw.WriteArrayHeader(1)
+				srv.mw.WriteUint8(uint8(col.Datatype))
+			}
+		}
+
+		return nil
+	}
+}
+
+// Record sends RESTYP_RECORD, with one value for each column of the current recordset.
+//
+// A nil value is NULL. Else, the Go type of a value depends on the column datatype:
+//
+//    BOOLEAN                       bool
+//    VARBINARY                     []byte
+//    VARCHAR, MONEY, NUMERIC       string
+//    BIT, TINYINT                  int or uint8
+//    SMALLINT, INT, BIGINT         int or int64
+//    FLOAT                         float64
+//    DATE, TIME, DATETIME          time.Time, whose date part is ignored for TIME
+//
+func Record(values ...interface{}) Response {
+
+	return func(srv *Server) error {
+		if len(values) != len(srv.layout) {
+			return fmt.Errorf("fakeserver Record: %d values for %d columns", len(values), len(srv.layout))
+		}
+
+		srv.mw.WriteUint8(uint8(rsqlib.RESTYP_RECORD))
+		srv.mw.WriteArrayHeader(uint32(len(values)))
+
+		for i, val := range values {
+			if err := srv.writeValue(srv.layout[i].Datatype, val); err != nil {
+				return fmt.Errorf("fakeserver Record: column %d: %s", i, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// RecordFinished sends RESTYP_RECORD_FINISHED, which terminates the current recordset. count is the record count.
+//
+func RecordFinished(count int64) Response {
+
+	return func(srv *Server) error {
+		srv.mw.WriteUint8(uint8(rsqlib.RESTYP_RECORD_FINISHED))
+		srv.mw.WriteInt64(count)
+
+		return nil
+	}
+}
+
+// Recordset sends a whole recordset, that is, Layout, a Record for each row, and RecordFinished.
+//
+func Recordset(columns []Column, rows ...[]interface{}) Response {
+
+	return func(srv *Server) error {
+		if err := Layout(columns...)(srv); err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err := Record(row...)(srv); err != nil {
+				return err
+			}
+		}
+
+		return RecordFinished(int64(len(rows)))(srv)
+	}
+}
+
+// ExecutionFinished sends RESTYP_EXECUTION_FINISHED, with the record count of an INSERT, UPDATE, DELETE, etc statement.
+//
+func ExecutionFinished(count int64) Response {
+
+	return func(srv *Server) error {
+		srv.mw.WriteUint8(uint8(rsqlib.RESTYP_EXECUTION_FINISHED))
+		srv.mw.WriteInt64(count)
+
+		return nil
+	}
+}
+
+// Print sends RESTYP_PRINT, as for a PRINT statement with a single VARCHAR argument.
+//
+func Print(text string) Response {
+
+	return func(srv *Server) error {
+		srv.mw.WriteUint8(uint8(rsqlib.RESTYP_PRINT))
+
+		srv.mw.WriteArrayHeader(1) // datatypes
+		srv.mw.WriteArrayHeader(3)
+		srv.mw.WriteUint8(uint8(rsqlib.DTYPE_VARCHAR))
+		srv.mw.WriteUint16(uint16(len(text)))
+		srv.mw.WriteBool(false)
+
+		srv.mw.WriteArrayHeader(1) // values
+		srv.mw.WriteString(text)
+
+		return nil
+	}
+}
+
+// Message sends RESTYP_MESSAGE, e.g. a progress message of BULK INSERT.
+//
+func Message(text string) Response {
+
+	return func(srv *Server) error {
+		srv.mw.WriteUint8(uint8(rsqlib.RESTYP_MESSAGE))
+		srv.mw.WriteString(text)
+
+		return nil
+	}
+}
+
+// Error sends RESTYP_ERROR. The server must then send BatchEnd, and close the connection if state is 127.
+//
+func Error(text string, state int64, lineNo int64, linePos int64) Response {
+
+	return func(srv *Server) error {
+		srv.mw.WriteUint8(uint8(rsqlib.RESTYP_ERROR))
+		srv.mw.WriteMapStrSimpleType(map[string]interface{}{
+			"category": "fakeserver",
+			"message":  "ERROR",
+			"severity": "ERROR",
+			"state":    state,
+			"text":     text,
+			"line_no":  lineNo,
+			"line_pos": linePos,
+		})
+
+		return nil
+	}
+}
+
+// BatchEnd sends RESTYP_BATCH_END, which terminates the batch. rc is the return code.
+//
+func BatchEnd(rc int64) Response {
+
+	return func(srv *Server) error {
+		srv.mw.WriteUint8(uint8(rsqlib.RESTYP_BATCH_END))
+		srv.mw.WriteInt64(rc)
+
+		return nil
+	}
+}
+
+// Raw sends data written by f, e.g. to test how the client handles a malformed or unknown response.
+//
+func Raw(f func(mw *msgp.Writer)) Response {
+
+	return func(srv *Server) error {
+		f(srv.mw)
+
+		return nil
+	}
+}
+
+//======================= values ================================
+
+// writeValue writes val, as the server sends it for a column of datatype dtype.
+//
+func (srv *Server) writeValue(dtype rsqlib.Dtype_t, val interface{}) error {
+
+	if val == nil {
+		srv.mw.WriteNil()
+		return nil
+	}
+
+	switch dtype {
+	case rsqlib.DTYPE_BOOLEAN:
+		if v, ok := val.(bool); ok {
+			srv.mw.WriteBool(v)
+			return nil
+		}
+
+	case rsqlib.DTYPE_VARBINARY:
+		if v, ok := val.([]byte); ok {
+			srv.mw.WriteBytes(v)
+			return nil
+		}
+
+	case rsqlib.DTYPE_VARCHAR, rsqlib.DTYPE_MONEY, rsqlib.DTYPE_NUMERIC:
+		if v, ok := val.(string); ok {
+			srv.mw.WriteString(v)
+			return nil
+		}
+
+	case rsqlib.DTYPE_BIT, rsqlib.DTYPE_TINYINT:
+		switch v := val.(type) {
+		case int:
+			srv.mw.WriteUint8(uint8(v))
+			return nil
+		case uint8:
+			srv.mw.WriteUint8(v)
+			return nil
+		}
+
+	case rsqlib.DTYPE_SMALLINT, rsqlib.DTYPE_INT, rsqlib.DTYPE_BIGINT:
+		switch v := val.(type) {
+		case int:
+			srv.mw.WriteInt64(int64(v))
+			return nil
+		case int64:
+			srv.mw.WriteInt64(v)
+			return nil
+		}
+
+	case rsqlib.DTYPE_FLOAT:
+		if v, ok := val.(float64); ok {
+			srv.mw.WriteFloat64(v)
+			return nil
+		}
+
+	case rsqlib.DTYPE_DATE, rsqlib.DTYPE_TIME, rsqlib.DTYPE_DATETIME:
+		if v, ok := val.(time.Time); ok {
+			v = v.UTC()
+
+			days := uint32((time.Date(v.Year(), v.Month(), v.Day(), 0, 0, 0, 0, time.UTC).Unix() - rsqlib.UNIX_SEC_LOWEST) / rsqlib.SECONDS_PER_DAY)
+			seconds := uint32(v.Hour()*3600 + v.Minute()*60 + v.Second())
+			ns := uint32(v.Nanosecond())
+
+			switch dtype {
+			case rsqlib.DTYPE_DATE:
+				srv.mw.WriteUint32(days)
+			case rsqlib.DTYPE_TIME:
+				srv.mw.WriteArrayHeader(2)
+				srv.mw.WriteUint32(seconds)
+				srv.mw.WriteUint32(ns)
+			default:
+				srv.mw.WriteArrayHeader(3)
+				srv.mw.WriteUint32(days)
+				srv.mw.WriteUint32(seconds)
+				srv.mw.WriteUint32(ns)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("value of type %T not supported for datatype %d", val, dtype)
+}
@@ -178,13 +178,28 @@ func AppendFloat64(dest []byte, f float64) []byte {
 	return dest
 }
 
+// OverflowError is the panic value of the Append functions when a string, byte slice, array or map is too large to be encoded as messagepack (size > math.MaxUint32).
+//
+// The Write methods of Writer recover from this panic, and put the error in the Writer error state.
+//
+type OverflowError string
+
+func (e OverflowError) Error() string {
+
+	return string(e)
+}
+
+// size_max is the largest size of a string, byte slice, array or map that can be encoded.
+// Tests lower it, as a value larger than 4 GB cannot be allocated.
+var size_max = math.MaxUint32
+
 func AppendString(dest []byte, s string) []byte {
 	var sz int
 
 	sz = len(s)
 
-	if sz > math.MaxUint32 {
-		panic("msgp: string too long")
+	if sz > size_max {
+		panic(OverflowError("msgp: string too long"))
 	}
 
 	dest = AppendStringHeader(dest, uint32(sz))
@@ -199,8 +214,8 @@ func AppendStringFromBytes(dest []byte, s []byte) []byte {
 
 	sz = len(s)
 
-	if sz > math.MaxUint32 {
-		panic("msgp: string too long")
+	if sz > size_max {
+		panic(OverflowError("msgp: string too long"))
 	}
 
 	dest = AppendStringHeader(dest, uint32(sz))
@@ -215,8 +230,8 @@ func AppendBytes(dest []byte, bts []byte) []byte {
 
 	sz = len(bts)
 
-	if sz > math.MaxUint32 {
-		panic("msgp: byte slice too long")
+	if sz > size_max {
+		panic(OverflowError("msgp: byte slice too long"))
 	}
 
 	dest = AppendBytesHeader(dest, uint32(sz))
@@ -226,6 +241,16 @@ func AppendBytes(dest []byte, bts []byte) []byte {
 	return dest
 }
 
+// AppendRaw appends raw to dest, as-is.
+//
+// It bypasses encoding: raw must already contain valid msgpack encoded values, e.g. bytes received from the server and forwarded by a proxy.
+// It is not checked.
+//
+func AppendRaw(dest []byte, raw []byte) []byte {
+
+	return append(dest, raw...)
+}
+
 func AppendStringHeader(dest []byte, sz uint32) []byte {
 
 	switch {
@@ -344,17 +369,39 @@ func AppendSimpleType(dest []byte, i interface{}) []byte {
 	case float64:
 		return AppendFloat64(dest, i)
 
+	case []interface{}: // nested array
+		return AppendArraySimpleType(dest, i)
+	case map[string]interface{}: // nested map
+		return AppendMapStrSimpleType(dest, i)
+
 	default:
 		panic("msgp: AppendIntf: type not supported")
 	}
 }
 
+func AppendArraySimpleType(dest []byte, a []interface{}) []byte {
+	var sz int
+
+	sz = len(a)
+	if sz > size_max {
+		panic(OverflowError("msgp: array has too many elements"))
+	}
+
+	dest = AppendArrayHeader(dest, uint32(sz))
+
+	for _, val := range a {
+		dest = AppendSimpleType(dest, val)
+	}
+
+	return dest
+}
+
 func AppendMapStrStr(dest []byte, m map[string]string) []byte {
 	var sz int
 
 	sz = len(m)
-	if sz > math.MaxUint32 {
-		panic("msgp: map has too many elements")
+	if sz > size_max {
+		panic(OverflowError("msgp: map has too many elements"))
 	}
 
 	dest = AppendMapHeader(dest, uint32(sz))
@@ -371,8 +418,8 @@ func AppendMapStrSimpleType(dest []byte, m map[string]interface{}) []byte {
 	var sz int
 
 	sz = len(m)
-	if sz > math.MaxUint32 {
-		panic("msgp: map has too many elements")
+	if sz > size_max {
+		panic(OverflowError("msgp: map has too many elements"))
 	}
 
 	dest = AppendMapHeader(dest, uint32(sz))
@@ -395,8 +442,8 @@ func AppendMapStrStrFromList(dest []byte, args ...string) []byte {
 
 	sz = sz / 2
 
-	if sz > math.MaxUint32 {
-		panic("msgp: map has too many elements")
+	if sz > size_max {
+		panic(OverflowError("msgp: map has too many elements"))
 	}
 
 	dest = AppendMapHeader(dest, uint32(sz))
@@ -0,0 +1,123 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package msgp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzz_seeds returns valid messagepack samples, used as seed corpus for the fuzz targets.
+//
+func fuzz_seeds() [][]byte {
+	var seeds [][]byte
+
+	seeds = append(seeds, AppendNil(nil))
+	seeds = append(seeds, AppendBool(nil, true))
+	seeds = append(seeds, AppendInt64(nil, -123456789))
+	seeds = append(seeds, AppendUint64(nil, 1<<40))
+	seeds = append(seeds, AppendFloat32(nil, 1.5))
+	seeds = append(seeds, AppendFloat64(nil, -2.25))
+	seeds = append(seeds, AppendString(nil, "Hello"))
+	seeds = append(seeds, AppendBytes(nil, []byte{1, 2, 3}))
+	seeds = append(seeds, AppendArraySimpleType(nil, []interface{}{int64(1), "a", nil}))
+	seeds = append(seeds, AppendMapStrSimpleType(nil, map[string]interface{}{"a": int64(1), "b": []interface{}{"c"}}))
+	seeds = append(seeds, []byte{M_STR32, 0xff, 0xff, 0xff, 0xff, 'a'}) // size larger than data
+	seeds = append(seeds, []byte{M_BIN32, 0x7f, 0xff, 0xff, 0xff})
+
+	return seeds
+}
+
+// FuzzReaderReadSimpleType checks that ReadSimpleType never panics, whatever the input.
+//
+func FuzzReaderReadSimpleType(f *testing.F) {
+
+	for _, seed := range fuzz_seeds() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := NewReader(bytes.NewReader(data))
+
+		for i := 0; i < 100; i++ { // read until error
+			if _, err := m.ReadSimpleType(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// FuzzReaderReadAll checks that no Read method panics, whatever the input.
+// The first byte selects the Read method used for each value.
+//
+func FuzzReaderReadAll(f *testing.F) {
+
+	for _, seed := range fuzz_seeds() {
+		for sel := byte(0); sel < 12; sel++ {
+			f.Add(append([]byte{sel}, seed...))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var err error
+
+		if len(data) == 0 {
+			return
+		}
+
+		sel := data[0]
+		m := NewReader(bytes.NewReader(data[1:]))
+
+		for i := 0; i < 100 && err == nil; i++ {
+			switch sel % 12 {
+			case 0:
+				_, err = m.ReadArrayHeader()
+			case 1:
+				_, err = m.ReadMapHeader()
+			case 2:
+				_, err = m.ReadString()
+			case 3:
+				_, err = m.ReadBytes(nil)
+			case 4:
+				_, err = m.ReadInt64()
+			case 5:
+				_, err = m.ReadUint64()
+			case 6:
+				_, err = m.ReadFloat64()
+			case 7:
+				_, err = m.ReadBool()
+			case 8:
+				err = m.ReadNil()
+			case 9:
+				_, err = m.ReadStringAsBytes(nil)
+			case 10:
+				_, err = m.ReadInt()
+			case 11:
+				_, err = m.NextType()
+				if err == nil {
+					_, err = m.ReadSimpleType()
+				}
+			}
+		}
+	})
+}
+
+// FuzzReaderSkip checks that Skip never panics, whatever the input.
+//
+func FuzzReaderSkip(f *testing.F) {
+
+	for _, seed := range fuzz_seeds() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := NewReader(bytes.NewReader(data))
+
+		for i := 0; i < 100; i++ { // skip until error
+			if err := m.Skip(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -33,6 +33,34 @@ const (
 	MapType
 )
 
+func (t Type) String() string {
+
+	switch t {
+	case BinType:
+		return "bin"
+	case StrType:
+		return "str"
+	case NilType:
+		return "nil"
+	case BoolType:
+		return "bool"
+	case UintType:
+		return "uint"
+	case IntType:
+		return "int"
+	case Float32Type:
+		return "float32"
+	case Float64Type:
+		return "float64"
+	case ArrayType:
+		return "array"
+	case MapType:
+		return "map"
+	default:
+		return "invalid"
+	}
+}
+
 func (m *Reader) NextType() (Type, error) {
 	var (
 		err    error
@@ -111,7 +139,8 @@ func (m *Reader) NextType() (Type, error) {
 //*******************************************
 
 const (
-	READER_SCRATCH_BUFFER_DEFAULT_CAPACITY = 1024 // ReadString() may need a large buffer, if string being read is large
+	READER_SCRATCH_BUFFER_DEFAULT_CAPACITY = 1024      // ReadString() may need a large buffer, if string being read is large
+	READER_SCRATCH_BUFFER_RETAIN_MAX       = 64 * 1024 // a scratch buffer grown larger than this for a large value is not kept for the next reads
 )
 
 // Reader reads msgpack data from a buffered reader.
@@ -128,6 +157,8 @@ const (
 type Reader struct {
 	br      *bufio.Reader // messagepack stream is read from this bufio.Reader
 	scratch []byte        // messagepack subparts (e.g. prefix byte, uint8, uint16 etc raw integers) are read from bufio.Reader into this little buffer to be decoded. ReadString() also reads the entire string into this buffer, before converting it to string.
+
+	scratch_retain_max int // if scratch has grown larger than this, it is released after use. If 0, it is always kept.
 }
 
 // NewReader returns a messagepack Reader.
@@ -147,10 +178,34 @@ func NewReader(rd io.Reader) *Reader {
 
 	m.br = br
 	m.scratch = make([]byte, 0, READER_SCRATCH_BUFFER_DEFAULT_CAPACITY)
+	m.scratch_retain_max = READER_SCRATCH_BUFFER_RETAIN_MAX
 
 	return m
 }
 
+// SetScratchRetainMax sets the maximum capacity of the internal scratch buffer that is kept between reads. By default, it is READER_SCRATCH_BUFFER_RETAIN_MAX.
+//
+// The scratch buffer grows to read a large string. If its capacity is larger than n, it is released after use, so that a single large value doesn't inflate the memory of a long-lived connection forever.
+// The next reads use the previous scratch buffer.
+//
+// If n is 0, the scratch buffer is always kept, and its capacity is the size of the largest string ever read.
+//
+func (m *Reader) SetScratchRetainMax(n int) {
+
+	m.scratch_retain_max = n
+}
+
+// keep_scratch keeps buff as the new scratch buffer, unless it is larger than the retain limit.
+//
+func (m *Reader) keep_scratch(buff []byte) {
+
+	if m.scratch_retain_max > 0 && cap(buff) > m.scratch_retain_max {
+		return // previous scratch buffer is kept, and buff will be garbage collected after use
+	}
+
+	m.scratch = buff
+}
+
 func error_bad_prefix(funcname string, prefix uint8) error {
 
 	return fmt.Errorf("msgp %s: bad prefix byte %08b", funcname, prefix)
@@ -160,6 +215,8 @@ func error_bad_prefix(funcname string, prefix uint8) error {
 //                  read_N
 //*******************************************
 
+const READ_CHUNK_SIZE = 1 << 20 // large strings and byte slices are read by chunks of 1 MB, so that a corrupted size doesn't allocate a huge buffer
+
 // read_N reads exactly n bytes from internal reader.
 // The internal m.scratch buffer is overwritten, and is returned to the caller, having length n.
 //
@@ -175,7 +232,7 @@ func (m *Reader) read_N(n int) ([]byte, error) {
 		return nil, err
 	}
 
-	m.scratch = buff
+	m.keep_scratch(buff)
 
 	return buff, nil
 }
@@ -188,9 +245,17 @@ func (m *Reader) read_N(n int) ([]byte, error) {
 //
 func (m *Reader) ReadNBytes(dest []byte, n int) (res []byte, err error) {
 
+	if n < 0 { // size read from a corrupted stream, on 32-bit platforms
+		return dest, fmt.Errorf("msgp: ReadNBytes negative size %d", n)
+	}
+
 	buff := dest
 	capacity := cap(buff)
 
+	if n > capacity && n-capacity > READ_CHUNK_SIZE { // the size comes from the stream, which can be corrupted. Don't allocate a huge buffer for data that may never arrive.
+		return m.read_n_bytes_chunked(dest, n)
+	}
+
 	if n > capacity {
 		extra := n - capacity
 		buff = append(buff[:capacity], make([]byte, extra)...)
@@ -205,6 +270,32 @@ func (m *Reader) ReadNBytes(dest []byte, n int) (res []byte, err error) {
 	return buff, nil
 }
 
+// read_n_bytes_chunked is the same as ReadNBytes, but the buffer grows by chunks of READ_CHUNK_SIZE bytes as the data arrive.
+//
+func (m *Reader) read_n_bytes_chunked(dest []byte, n int) (res []byte, err error) {
+
+	buff := dest[:0]
+
+	for len(buff) < n {
+		chunk := n - len(buff)
+		if chunk > READ_CHUNK_SIZE {
+			chunk = READ_CHUNK_SIZE
+		}
+
+		start := len(buff)
+		buff = append(buff, make([]byte, chunk)...)
+
+		if _, err := io.ReadFull(m.br, buff[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return dest, err
+		}
+	}
+
+	return buff, nil
+}
+
 //*******************************************
 //           utility functions
 //*******************************************
@@ -466,6 +557,24 @@ func (m *Reader) ReadUint64() (val uint64, err error) {
 	return val, nil
 }
 
+// ReadUint reads an unsigned integer into a Go uint. On 32-bit platforms, an error is returned if the value overflows uint.
+//
+func (m *Reader) ReadUint() (val uint, err error) {
+	var in uint64
+
+	if in, err = m.ReadUint64(); err != nil {
+		return 0, err
+	}
+
+	if in > math.MaxUint {
+		return 0, fmt.Errorf("msgp: ReadUint overflow, read %d", in)
+	}
+
+	val = uint(in)
+
+	return val, nil
+}
+
 func (m *Reader) ReadInt8() (val int8, err error) {
 	var in int64
 
@@ -568,6 +677,24 @@ func (m *Reader) ReadInt64() (val int64, err error) {
 	return val, nil
 }
 
+// ReadInt reads a signed integer into a Go int. On 32-bit platforms, an error is returned if the value overflows int.
+//
+func (m *Reader) ReadInt() (val int, err error) {
+	var in int64
+
+	if in, err = m.ReadInt64(); err != nil {
+		return 0, err
+	}
+
+	if in < math.MinInt || in > math.MaxInt {
+		return 0, fmt.Errorf("msgp: ReadInt overflow, read %d", in)
+	}
+
+	val = int(in)
+
+	return val, nil
+}
+
 func (m *Reader) ReadFloat32() (val float32, err error) {
 	var (
 		prefix     uint8
@@ -621,11 +748,60 @@ func (m *Reader) ReadString() (val string, err error) {
 		return "", err
 	}
 
-	m.scratch = buff
+	m.keep_scratch(buff)
 
 	return string(buff), nil
 }
 
+// ReadStringInto reads a string and writes its bytes directly into dst, e.g. a *strings.Builder or a *bufio.Writer, without creating an intermediate string.
+// It returns the number of bytes written.
+//
+// The bytes are copied from the internal bufio.Reader, so that no allocation is done.
+//
+func (m *Reader) ReadStringInto(dst io.Writer) (n int64, err error) {
+	var (
+		sz uint32
+		p  []byte
+	)
+
+	if sz, err = m.ReadStringHeader(); err != nil {
+		return 0, err
+	}
+
+	remaining := int(sz)
+
+	for remaining > 0 {
+		if m.br.Buffered() == 0 { // fill the buffer
+			if _, err = m.br.Peek(1); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return n, err
+			}
+		}
+
+		count := m.br.Buffered()
+		if count > remaining {
+			count = remaining
+		}
+
+		if p, err = m.br.Peek(count); err != nil { // never fails, as count bytes are buffered
+			return n, err
+		}
+
+		written, err := dst.Write(p)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+
+		m.br.Discard(count)
+		remaining -= count
+	}
+
+	return n, nil
+}
+
 func (m *Reader) ReadStringAsBytes(dest []byte) (res []byte, err error) {
 	var (
 		buff []byte
@@ -814,13 +990,69 @@ func (m *Reader) ReadMapHeader() (sz uint32, err error) {
 	}
 }
 
+// Buffered returns the number of bytes that can be read from the internal bufio.Reader without reading from the underlying io.Reader.
+//
+func (m *Reader) Buffered() int {
+
+	return m.br.Buffered()
+}
+
+// PeekPositiveFixint returns the value of the positive fixint (0 to 127) that is the next item in the stream, without consuming it.
+//
+// It blocks until at least one byte is available. If the next item is not a positive fixint, an error is returned.
+//
+func (m *Reader) PeekPositiveFixint() (val uint8, err error) {
+	var (
+		prefix uint8
+	)
+
+	if prefix, err = m.peek_byte(); err != nil {
+		return 0, err
+	}
+
+	if prefix > 127 {
+		return 0, error_bad_prefix("peek positive fixint", prefix)
+	}
+
+	return prefix, nil
+}
+
 // ReadFull is a method that just calls io.ReadFull.
 //
+// It reads raw bytes from the stream, which are not decoded as msgpack. To read a msgpack bin value into an existing array, use ReadInto.
+//
 func (m *Reader) ReadFull(dest []byte) (n int, err error) {
 
 	return io.ReadFull(m.br, dest)
 }
 
+// ReadInto reads a msgpack bin value, whose size must be exactly len(dst), and copies its bytes directly from the internal bufio.Reader into dst.
+// It returns the number of bytes copied, which is len(dst) if no error occurred.
+//
+// Unlike ReadBytes or ReadNBytes, which return a slice that may be grown, nothing is allocated. It is convenient to decode fixed-width binary fields into an existing array, e.g. a [16]byte UUID:
+//
+//    var uuid [16]byte
+//
+//    _, err = m.ReadInto(uuid[:])
+//
+// If the size of the bin value is not len(dst), an error is returned and the value is not read, so that the stream is positioned just after its header.
+//
+func (m *Reader) ReadInto(dst []byte) (n int, err error) {
+	var (
+		sz uint32
+	)
+
+	if sz, err = m.ReadBytesHeader(); err != nil {
+		return 0, err
+	}
+
+	if uint64(sz) != uint64(len(dst)) {
+		return 0, fmt.Errorf("msgp: ReadInto size %d, expected %d", sz, len(dst))
+	}
+
+	return io.ReadFull(m.br, dst)
+}
+
 func (m *Reader) ReadSimpleType() (interface{}, error) {
 	var (
 		err     error
@@ -860,3 +1092,207 @@ func (m *Reader) ReadSimpleType() (interface{}, error) {
 		return nil, fmt.Errorf("msgp: ReadSimpleType: type not supported")
 	}
 }
+
+// ReadMapStrIntf reads a map whose keys are strings, and returns it as a map[string]interface{}.
+//
+// Values can be nil, scalars as returned by ReadSimpleType, arrays returned as []interface{}, and maps with string keys returned as map[string]interface{}, nested up to SKIP_DEPTH_MAX levels.
+// It is useful to decode messages whose fields may be added by the server in a later version, as all the fields are returned.
+//
+func (m *Reader) ReadMapStrIntf() (map[string]interface{}, error) {
+
+	return m.read_map_str_intf(0)
+}
+
+func (m *Reader) read_map_str_intf(depth int) (map[string]interface{}, error) {
+	var (
+		err error
+		sz  uint32
+		key string
+		val interface{}
+	)
+
+	if sz, err = m.ReadMapHeader(); err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]interface{}, min(int(sz), 64)) // size comes from the stream, don't trust it for allocation
+
+	for i := uint32(0); i < sz; i++ {
+		if key, err = m.ReadString(); err != nil {
+			return nil, err
+		}
+
+		if val, err = m.read_intf(depth + 1); err != nil {
+			return nil, err
+		}
+
+		res[key] = val
+	}
+
+	return res, nil
+}
+
+// read_intf reads a value of any type supported by ReadMapStrIntf.
+//
+func (m *Reader) read_intf(depth int) (interface{}, error) {
+	var (
+		err     error
+		objtype Type
+		sz      uint32
+	)
+
+	if depth > SKIP_DEPTH_MAX {
+		return nil, fmt.Errorf("msgp: ReadMapStrIntf: nesting deeper than %d", SKIP_DEPTH_MAX)
+	}
+
+	if objtype, err = m.NextType(); err != nil {
+		return nil, err
+	}
+
+	switch objtype {
+	case ArrayType:
+		if sz, err = m.ReadArrayHeader(); err != nil {
+			return nil, err
+		}
+
+		res := make([]interface{}, 0, min(int(sz), 64))
+
+		for i := uint32(0); i < sz; i++ {
+			val, err := m.read_intf(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, val)
+		}
+
+		return res, nil
+
+	case MapType:
+		return m.read_map_str_intf(depth)
+
+	default:
+		return m.ReadSimpleType()
+	}
+}
+
+const SKIP_DEPTH_MAX = 64 // maximum nesting of arrays and maps skipped by Skip, so that a corrupted stream cannot exhaust the stack
+
+// Skip reads the next value and discards it, whatever its type.
+//
+// Arrays and maps are skipped element by element, recursively, as each element can be of any type: nil, scalar, or nested array or map.
+// Strings and byte slices are discarded directly from the internal bufio.Reader, without allocation.
+//
+// It is useful to ignore a value that the client doesn't know, e.g. a field added in a later version of the protocol.
+//
+func (m *Reader) Skip() error {
+
+	return m.skip(0)
+}
+
+func (m *Reader) skip(depth int) error {
+	var (
+		err     error
+		objtype Type
+		sz      uint32
+	)
+
+	if depth > SKIP_DEPTH_MAX {
+		return fmt.Errorf("msgp: Skip: nesting deeper than %d", SKIP_DEPTH_MAX)
+	}
+
+	if objtype, err = m.NextType(); err != nil {
+		return err
+	}
+
+	switch objtype {
+	case NilType:
+		return m.ReadNil()
+
+	case BoolType:
+		_, err = m.ReadBool()
+		return err
+
+	case UintType:
+		_, err = m.ReadUint64()
+		return err
+
+	case IntType:
+		_, err = m.ReadInt64()
+		return err
+
+	case Float32Type:
+		_, err = m.ReadFloat32()
+		return err
+
+	case Float64Type:
+		_, err = m.ReadFloat64()
+		return err
+
+	case BinType:
+		if sz, err = m.ReadBytesHeader(); err != nil {
+			return err
+		}
+		return m.discard_n(sz)
+
+	case StrType:
+		if sz, err = m.ReadStringHeader(); err != nil {
+			return err
+		}
+		return m.discard_n(sz)
+
+	case ArrayType:
+		if sz, err = m.ReadArrayHeader(); err != nil {
+			return err
+		}
+
+		for i := uint32(0); i < sz; i++ {
+			if err = m.skip(depth + 1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case MapType:
+		if sz, err = m.ReadMapHeader(); err != nil {
+			return err
+		}
+
+		for i := uint32(0); i < sz; i++ {
+			if err = m.skip(depth + 1); err != nil { // key
+				return err
+			}
+
+			if err = m.skip(depth + 1); err != nil { // value
+				return err
+			}
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("msgp: Skip: type not supported")
+	}
+}
+
+// discard_n discards the next sz bytes of the stream.
+//
+func (m *Reader) discard_n(sz uint32) error {
+
+	remaining := uint64(sz)
+
+	for remaining > 0 {
+		count := min(remaining, READ_CHUNK_SIZE)
+
+		if _, err := m.br.Discard(int(count)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		remaining -= count
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ package msgp
 
 import (
 	"bytes"
+	"io"
 	"math"
 	"strings"
 	"testing"
@@ -1192,3 +1193,605 @@ func Test_map_header(t *testing.T) {
 		}
 	}
 }
+
+func Test_buffered(t *testing.T) {
+	var (
+		err error
+		bbb []byte
+	)
+
+	bbb = AppendUint8(bbb[:0], 200)
+	bbb = AppendString(bbb, "hello")
+
+	buff := bytes.NewBuffer(bbb)
+	m := NewReader(buff)
+
+	if n := m.Buffered(); n != 0 { // nothing has been read yet from underlying reader
+		t.Fatalf("buffered %d != %d", n, 0)
+	}
+
+	if _, err = m.ReadUint8(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if n := m.Buffered(); n != len(bbb)-2 { // uint8 200 is encoded with 2 bytes
+		t.Fatalf("buffered %d != %d", n, len(bbb)-2)
+	}
+
+	if _, err = m.ReadString(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if n := m.Buffered(); n != 0 {
+		t.Fatalf("buffered %d != %d", n, 0)
+	}
+}
+
+func Test_array_simple_type(t *testing.T) {
+	var (
+		err error
+		bbb []byte
+		sz  uint32
+		res interface{}
+	)
+
+	sample := []interface{}{nil, true, int64(-3), uint64(300), 1.5, "hello", []byte{1, 2}}
+
+	bbb = AppendArraySimpleType(bbb[:0], sample)
+
+	buff := bytes.NewBuffer(bbb)
+	m := NewReader(buff)
+
+	if sz, err = m.ReadArrayHeader(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if int(sz) != len(sample) {
+		t.Fatalf("size %d != %d", sz, len(sample))
+	}
+
+	for i, expected := range sample {
+		if res, err = m.ReadSimpleType(); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if e, ok := expected.([]byte); ok {
+			if bytes.Equal(res.([]byte), e) == false {
+				t.Fatalf("element %d: result %v != %v", i, res, expected)
+			}
+			continue
+		}
+
+		if res != expected {
+			t.Fatalf("element %d: result %v != %v", i, res, expected)
+		}
+	}
+}
+
+func Test_nested_simple_type(t *testing.T) {
+	var (
+		err error
+		bbb []byte
+		sz  uint32
+		key string
+		res interface{}
+	)
+
+	sample := map[string]interface{}{
+		"list": []interface{}{int64(1), map[string]interface{}{"a": "b"}},
+	}
+
+	bbb = AppendSimpleType(bbb[:0], sample)
+
+	buff := bytes.NewBuffer(bbb)
+	m := NewReader(buff)
+
+	if sz, err = m.ReadMapHeader(); err != nil || sz != 1 {
+		t.Fatalf("map header: %d %v", sz, err)
+	}
+
+	if key, err = m.ReadString(); err != nil || key != "list" {
+		t.Fatalf("key: %s %v", key, err)
+	}
+
+	if sz, err = m.ReadArrayHeader(); err != nil || sz != 2 {
+		t.Fatalf("array header: %d %v", sz, err)
+	}
+
+	if res, err = m.ReadSimpleType(); err != nil || res != int64(1) {
+		t.Fatalf("element 0: %v %v", res, err)
+	}
+
+	if sz, err = m.ReadMapHeader(); err != nil || sz != 1 {
+		t.Fatalf("nested map header: %d %v", sz, err)
+	}
+
+	if key, err = m.ReadString(); err != nil || key != "a" {
+		t.Fatalf("nested key: %s %v", key, err)
+	}
+
+	if res, err = m.ReadSimpleType(); err != nil || res != "b" {
+		t.Fatalf("nested value: %v %v", res, err)
+	}
+}
+
+func Test_peek_positive_fixint(t *testing.T) {
+	var (
+		err error
+		bbb []byte
+		val uint8
+	)
+
+	bbb = AppendUint8(bbb[:0], 14)
+	bbb = AppendString(bbb, "x")
+
+	buff := bytes.NewBuffer(bbb)
+	m := NewReader(buff)
+
+	if val, err = m.PeekPositiveFixint(); err != nil || val != 14 {
+		t.Fatalf("peek: %d %v", val, err)
+	}
+
+	if val, err = m.ReadUint8(); err != nil || val != 14 { // peek has not consumed the value
+		t.Fatalf("read: %d %v", val, err)
+	}
+
+	if _, err = m.PeekPositiveFixint(); err == nil {
+		t.Fatalf("peek on string should fail")
+	}
+}
+
+func Test_writer_overflow(t *testing.T) {
+
+	defer func(saved int) { size_max = saved }(size_max)
+
+	small := strings.Repeat("x", 100)                             // copied into staging by AppendString
+	large := strings.Repeat("x", 2*WRITER_LARGE_STRING_THRESHOLD) // written directly to the bufio.Writer
+
+	tests := []struct {
+		name  string
+		max   int
+		write func(mw *Writer)
+	}{
+		{"WriteString small", 10, func(mw *Writer) { mw.WriteString(small) }},
+		{"WriteString large", WRITER_LARGE_STRING_THRESHOLD, func(mw *Writer) { mw.WriteString(large) }},
+		{"WriteStringFromBytes small", 10, func(mw *Writer) { mw.WriteStringFromBytes([]byte(small)) }},
+		{"WriteStringFromBytes large", WRITER_LARGE_STRING_THRESHOLD, func(mw *Writer) { mw.WriteStringFromBytes([]byte(large)) }},
+		{"WriteBytes", 10, func(mw *Writer) { mw.WriteBytes([]byte(small)) }},
+		{"WriteArraySimpleType", 2, func(mw *Writer) { mw.WriteArraySimpleType([]interface{}{1, 2, 3}) }},
+		{"batched WriteString", 10, func(mw *Writer) { mw.BeginBatch(); mw.WriteString(small); mw.EndBatch() }},
+	}
+
+	for _, tt := range tests {
+		var buff bytes.Buffer
+
+		size_max = tt.max
+		mw := NewWriter(&buff)
+
+		tt.write(mw)
+
+		if _, ok := mw.Error().(OverflowError); ok == false {
+			t.Fatalf("%s: writer should be in OverflowError state, got %v", tt.name, mw.Error())
+		}
+
+		mw.WriteString("abc") // ignored, as Writer is in error state
+
+		if err := mw.Flush(); err == nil || buff.Len() != 0 {
+			t.Fatalf("%s: flush should fail and nothing should be written: %v %d", tt.name, err, buff.Len())
+		}
+	}
+
+	size_max = 10 // a value of exactly size_max is accepted
+
+	var buff bytes.Buffer
+
+	mw := NewWriter(&buff)
+	mw.WriteString("0123456789")
+
+	if err := mw.Flush(); err != nil || buff.Len() != 11 {
+		t.Fatalf("string of size_max bytes should be written: %v %d", err, buff.Len())
+	}
+}
+
+func Test_read_int_uint(t *testing.T) {
+	var (
+		err  error
+		bbb  []byte
+		ival int
+		uval uint
+	)
+
+	bbb = AppendInt64(bbb[:0], -123456)
+	bbb = AppendUint64(bbb, 123456)
+	bbb = AppendInt64(bbb, math.MinInt32)
+	bbb = AppendString(bbb, "x")
+
+	buff := bytes.NewBuffer(bbb)
+	m := NewReader(buff)
+
+	if ival, err = m.ReadInt(); err != nil || ival != -123456 {
+		t.Fatalf("ReadInt: %d %v", ival, err)
+	}
+
+	if uval, err = m.ReadUint(); err != nil || uval != 123456 {
+		t.Fatalf("ReadUint: %d %v", uval, err)
+	}
+
+	if ival, err = m.ReadInt(); err != nil || ival != math.MinInt32 {
+		t.Fatalf("ReadInt: %d %v", ival, err)
+	}
+
+	if _, err = m.ReadInt(); err == nil {
+		t.Fatalf("ReadInt on string should fail")
+	}
+}
+
+func Test_read_string_into(t *testing.T) {
+	var (
+		err error
+		bbb []byte
+		n   int64
+		sb  strings.Builder
+	)
+
+	long := strings.Repeat("abcdefghij", 1000) // larger than bufio.Reader default buffer size
+
+	bbb = AppendString(bbb[:0], "Hello")
+	bbb = AppendString(bbb, long)
+	bbb = AppendString(bbb, "")
+
+	buff := bytes.NewBuffer(bbb)
+	m := NewReader(buff)
+
+	if n, err = m.ReadStringInto(&sb); err != nil || n != 5 || sb.String() != "Hello" {
+		t.Fatalf("short string: %d %v %q", n, err, sb.String())
+	}
+
+	sb.Reset()
+
+	if n, err = m.ReadStringInto(&sb); err != nil || n != int64(len(long)) || sb.String() != long {
+		t.Fatalf("long string: %d %v", n, err)
+	}
+
+	sb.Reset()
+
+	if n, err = m.ReadStringInto(&sb); err != nil || n != 0 || sb.String() != "" {
+		t.Fatalf("empty string: %d %v", n, err)
+	}
+
+	if _, err = m.ReadStringInto(&sb); err == nil {
+		t.Fatalf("error was expected at end of stream")
+	}
+}
+
+func Test_write_large_string(t *testing.T) {
+	var (
+		buff bytes.Buffer
+	)
+
+	long := strings.Repeat("abcdefghij", 1000) // larger than WRITER_LARGE_STRING_THRESHOLD
+
+	mw := NewWriter(&buff)
+	mw.WriteString("Hello")
+	mw.WriteString(long)
+	mw.WriteStringFromBytes([]byte(long))
+
+	if err := mw.Flush(); err != nil {
+		t.Fatalf("flush: %s", err)
+	}
+
+	expected := AppendString(nil, "Hello")
+	expected = AppendString(expected, long)
+	expected = AppendString(expected, long)
+
+	if bytes.Equal(buff.Bytes(), expected) == false {
+		t.Fatalf("large string is not encoded as by AppendString")
+	}
+
+	if cap(mw.staging) > WRITER_STAGING_BUFFER_DEFAULT_CAPACITY {
+		t.Fatalf("staging should not grow for large strings, cap is %d", cap(mw.staging))
+	}
+}
+
+var benchmark_large_string = strings.Repeat("a", 1<<20)
+
+func Benchmark_write_large_string(b *testing.B) {
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		mw := NewWriter(io.Discard)
+		mw.WriteString(benchmark_large_string)
+		mw.Flush()
+	}
+}
+
+func Benchmark_write_large_string_staging(b *testing.B) { // previous implementation, copying the whole string into staging
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		mw := NewWriter(io.Discard)
+		mw.SetStaging(AppendString(mw.TruncatedStaging(), benchmark_large_string))
+		mw.WriteStaging()
+		mw.Flush()
+	}
+}
+
+func Test_write_raw(t *testing.T) {
+	var (
+		buff bytes.Buffer
+	)
+
+	raw := AppendString(nil, "Hello")
+	raw = AppendInt64(raw, 1234)
+
+	if bytes.Equal(AppendRaw([]byte{M_NIL}, raw), append([]byte{M_NIL}, raw...)) == false {
+		t.Fatalf("AppendRaw should append bytes as-is")
+	}
+
+	mw := NewWriter(&buff)
+	mw.WriteNil()
+	mw.WriteRaw(raw)
+
+	if err := mw.Flush(); err != nil {
+		t.Fatalf("flush: %s", err)
+	}
+
+	m := NewReader(&buff)
+
+	if err := m.ReadNil(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if s, err := m.ReadString(); err != nil || s != "Hello" {
+		t.Fatalf("%q %v", s, err)
+	}
+
+	if i, err := m.ReadInt64(); err != nil || i != 1234 {
+		t.Fatalf("%d %v", i, err)
+	}
+}
+
+func Test_write_batch(t *testing.T) {
+	var (
+		plain   bytes.Buffer
+		batched bytes.Buffer
+	)
+
+	large := strings.Repeat("x", 2*WRITER_LARGE_STRING_THRESHOLD)
+	raw := AppendInt64(nil, -5)
+
+	write := func(mw *Writer) {
+		mw.WriteUint8(7)
+		mw.WriteString("Hello")
+		mw.WriteString(large)
+		mw.WriteStringFromBytes([]byte(large))
+		mw.WriteFloat64(1.5)
+		mw.WriteRaw(raw)
+		mw.WriteNil()
+	}
+
+	mw := NewWriter(&plain)
+	write(mw)
+	if err := mw.Flush(); err != nil {
+		t.Fatalf("flush: %s", err)
+	}
+
+	mw = NewWriter(&batched)
+	mw.BeginBatch()
+	write(mw)
+
+	if n := mw.bw.Buffered() + batched.Len(); n != 0 {
+		t.Fatalf("nothing should be written to the bufio.Writer before EndBatch, got %d bytes", n)
+	}
+
+	mw.EndBatch()
+
+	if n := mw.bw.Buffered() + batched.Len(); n != plain.Len() { // large write may bypass the bufio buffer
+		t.Fatalf("EndBatch should write %d bytes, got %d", plain.Len(), n)
+	}
+
+	mw.EndBatch() // no-op
+
+	if err := mw.Flush(); err != nil {
+		t.Fatalf("flush: %s", err)
+	}
+
+	if bytes.Equal(plain.Bytes(), batched.Bytes()) == false {
+		t.Fatalf("batched output differs from plain output")
+	}
+
+	// Flush ends the batch
+
+	batched.Reset()
+	mw.BeginBatch()
+	write(mw)
+
+	if err := mw.Flush(); err != nil {
+		t.Fatalf("flush: %s", err)
+	}
+
+	if bytes.Equal(plain.Bytes(), batched.Bytes()) == false {
+		t.Fatalf("Flush in batched mode: output differs from plain output")
+	}
+
+	// back to normal mode, each value is written immediately
+
+	mw.WriteNil()
+	if mw.bw.Buffered() != 1 {
+		t.Fatalf("after Flush, Writer should not be in batched mode")
+	}
+}
+
+func Test_scratch_retain_max(t *testing.T) {
+	var (
+		bbb []byte
+	)
+
+	long := strings.Repeat("a", 2*READER_SCRATCH_BUFFER_RETAIN_MAX)
+
+	bbb = AppendString(bbb, long)
+	bbb = AppendString(bbb, "Hello")
+	bbb = AppendString(bbb, long)
+
+	m := NewReader(bytes.NewReader(bbb))
+
+	if s, err := m.ReadString(); err != nil || s != long {
+		t.Fatalf("long string: %v", err)
+	}
+
+	if cap(m.scratch) > READER_SCRATCH_BUFFER_RETAIN_MAX {
+		t.Fatalf("large scratch buffer should not be kept, cap is %d", cap(m.scratch))
+	}
+
+	if s, err := m.ReadString(); err != nil || s != "Hello" {
+		t.Fatalf("short string: %q %v", s, err)
+	}
+
+	m.SetScratchRetainMax(0)
+
+	if s, err := m.ReadString(); err != nil || s != long {
+		t.Fatalf("long string: %v", err)
+	}
+
+	if cap(m.scratch) < len(long) {
+		t.Fatalf("scratch buffer should be kept if no limit, cap is %d", cap(m.scratch))
+	}
+}
+
+func Test_read_into(t *testing.T) {
+	var (
+		bbb  []byte
+		uuid [16]byte
+	)
+
+	val := []byte("0123456789abcdef")
+
+	bbb = AppendBytes(bbb, val)
+	bbb = AppendBytes(bbb, []byte("short"))
+
+	m := NewReader(bytes.NewReader(bbb))
+
+	if n, err := m.ReadInto(uuid[:]); err != nil || n != 16 || bytes.Equal(uuid[:], val) == false {
+		t.Fatalf("%d %v %q", n, err, uuid)
+	}
+
+	if _, err := m.ReadInto(uuid[:]); err == nil {
+		t.Fatalf("error was expected for size mismatch")
+	}
+}
+
+func Test_skip(t *testing.T) {
+	var (
+		bbb []byte
+	)
+
+	// map whose values are nil, scalars, and arrays of maps
+
+	bbb = AppendMapHeader(bbb, 4)
+	bbb = AppendString(bbb, "nil")
+	bbb = AppendNil(bbb)
+	bbb = AppendString(bbb, "scalar")
+	bbb = AppendFloat64(bbb, 1.5)
+	bbb = AppendString(bbb, "arrays of maps")
+	bbb = AppendArrayHeader(bbb, 2)
+	bbb = AppendMapStrSimpleType(bbb, map[string]interface{}{"a": int64(1), "b": nil})
+	bbb = AppendMapHeader(bbb, 1)
+	bbb = AppendString(bbb, "nested")
+	bbb = AppendArrayHeader(bbb, 0)
+	bbb = AppendString(bbb, "long")
+	bbb = AppendBytes(bbb, bytes.Repeat([]byte{0xc1}, 70000)) // bytes that are not valid prefixes must be skipped as data
+
+	// array of mixed types
+
+	bbb = AppendArrayHeader(bbb, 6)
+	bbb = AppendNil(bbb)
+	bbb = AppendBool(bbb, true)
+	bbb = AppendUint64(bbb, math.MaxUint64)
+	bbb = AppendInt64(bbb, -1)
+	bbb = AppendString(bbb, "Hello")
+	bbb = AppendMapHeader(bbb, 1)
+	bbb = AppendInt64(bbb, 1) // key is not a string
+	bbb = AppendArraySimpleType(bbb, []interface{}{nil, "x"})
+
+	bbb = AppendString(bbb, "end")
+
+	m := NewReader(bytes.NewReader(bbb))
+
+	for i := 0; i < 2; i++ {
+		if err := m.Skip(); err != nil {
+			t.Fatalf("skip %d: %s", i, err)
+		}
+	}
+
+	if s, err := m.ReadString(); err != nil || s != "end" {
+		t.Fatalf("stream is not positioned after skipped values: %q %v", s, err)
+	}
+
+	// errors
+
+	for _, bbb := range [][]byte{{0xc1}, {M_STR8, 10, 'a'}, AppendArrayHeader(nil, 3)} {
+		if err := NewReader(bytes.NewReader(bbb)).Skip(); err == nil {
+			t.Fatalf("% x: error was expected", bbb)
+		}
+	}
+
+	deep := bytes.Repeat([]byte{M_FIXARRAY_BASE | 1}, SKIP_DEPTH_MAX+2)
+	if err := NewReader(bytes.NewReader(deep)).Skip(); err == nil {
+		t.Fatalf("error was expected for deep nesting")
+	}
+}
+
+func Test_read_map_str_intf(t *testing.T) {
+	var (
+		bbb []byte
+	)
+
+	bbb = AppendMapHeader(bbb, 4)
+	bbb = AppendString(bbb, "name")
+	bbb = AppendString(bbb, "abc")
+	bbb = AppendString(bbb, "count")
+	bbb = AppendInt64(bbb, -3)
+	bbb = AppendString(bbb, "list")
+	bbb = AppendArraySimpleType(bbb, []interface{}{int64(-1), "x", nil})
+	bbb = AppendString(bbb, "nested")
+	bbb = AppendMapStrSimpleType(bbb, map[string]interface{}{"flag": true})
+	bbb = AppendString(bbb, "next")
+
+	m := NewReader(bytes.NewReader(bbb))
+
+	res, err := m.ReadMapStrIntf()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if res["name"] != "abc" || res["count"] != int64(-3) {
+		t.Fatalf("bad scalar values %v", res)
+	}
+
+	if list, ok := res["list"].([]interface{}); !ok || len(list) != 3 || list[0] != int64(-1) || list[1] != "x" || list[2] != nil {
+		t.Fatalf("bad array %v", res["list"])
+	}
+
+	if nested, ok := res["nested"].(map[string]interface{}); !ok || nested["flag"] != true {
+		t.Fatalf("bad nested map %v", res["nested"])
+	}
+
+	if s, err := m.ReadString(); err != nil || s != "next" {
+		t.Fatalf("stream out of sync: %q %v", s, err)
+	}
+
+	// too deep
+
+	bbb = nil
+	for i := 0; i < SKIP_DEPTH_MAX+2; i++ {
+		bbb = AppendMapHeader(bbb, 1)
+		bbb = AppendString(bbb, "k")
+	}
+	bbb = AppendNil(bbb)
+
+	if _, err = NewReader(bytes.NewReader(bbb)).ReadMapStrIntf(); err == nil {
+		t.Fatalf("error expected for too deep nesting")
+	}
+}
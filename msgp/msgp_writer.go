@@ -14,6 +14,7 @@ import (
 
 const (
 	WRITER_STAGING_BUFFER_DEFAULT_CAPACITY = 1024 // quite large because large string can be written
+	WRITER_LARGE_STRING_THRESHOLD          = 4096 // strings larger than this are written directly to the bufio.Writer, without being copied into staging
 )
 
 // Writer writes msgpack data to a buffered writer.
@@ -24,12 +25,15 @@ const (
 // Then, when all you have written all your data, you MUST call Flush() to flush the underlying bufio.Writer, and check its return value for error.
 //
 //      Note: the doomed field is an error, that occurs because Write has failed. Most probably because connection is broken.
+//            It is also set if a value is too large to be encoded (OverflowError), e.g. a string longer than math.MaxUint32.
 //            When such failure occurs, it is unrecoverable and the connection should be just closed. The Writer cannot be used any more.
 //
 type Writer struct {
 	bw      *bufio.Writer
 	staging []byte // data are encoded as messagepack in this staging buffer before being sent to the bufio.Writer.
 	doomed  error  // if not nil, a Write() has failed. It is a unrecoverable error, the connection is certainly broken.
+
+	batching bool // if true, Write methods only append to staging, which is written by EndBatch or Flush
 }
 
 // NewWriter returns a messagepack Writer.
@@ -75,19 +79,58 @@ func (mw *Writer) WriteStaging() {
 	}
 }
 
-//******************************************************************************
-//         Write methods
-//         they append msgpack encoded value to the internal mw.staging buffer
-//         and write the buffer to the underlying bufio.Writer
-//******************************************************************************
+// BeginBatch switches the Writer to batched mode. The following Write methods only append their encoded value to the staging buffer, and nothing is written to the bufio.Writer.
+// EndBatch or Flush writes the whole staging buffer at once.
+//
+// It is convenient to compose a message from many small values with a single write:
+//
+//    mw.BeginBatch()
+//    mw.WriteUint8(uint8(REQTYP_BATCH))
+//    mw.WriteString(text)
+//    mw.EndBatch()           // or Flush, which also ends the batch
+//
+// In batched mode, large strings are also copied into the staging buffer, which grows to the size of the whole message.
+//
+func (mw *Writer) BeginBatch() {
 
-func (mw *Writer) WriteNil() {
+	mw.batching = true
+	mw.staging = mw.staging[:0]
+}
 
-	if mw.doomed != nil {
+// EndBatch writes the values staged since BeginBatch to the bufio.Writer, and switches the Writer back to normal mode.
+// If the Writer is not in batched mode, it does nothing.
+//
+func (mw *Writer) EndBatch() {
+
+	if mw.batching == false {
 		return
 	}
 
-	mw.staging = AppendNil(mw.staging[:0])
+	mw.batching = false
+
+	mw.WriteStaging()
+	mw.staging = mw.staging[:0]
+}
+
+// staging_base returns the staging buffer to which the Write methods append the encoded value.
+// In batched mode, the value is appended to the values already staged. Else, the staging buffer is truncated.
+//
+func (mw *Writer) staging_base() []byte {
+
+	if mw.batching {
+		return mw.staging
+	}
+
+	return mw.staging[:0]
+}
+
+// write_staging writes the staging buffer to the bufio.Writer, except in batched mode.
+//
+func (mw *Writer) write_staging() {
+
+	if mw.batching {
+		return
+	}
 
 	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
 		mw.doomed = err
@@ -95,46 +138,93 @@ func (mw *Writer) WriteNil() {
 	}
 }
 
-func (mw *Writer) WriteBool(val bool) {
+// WriteRaw writes raw to the underlying bufio.Writer, as-is.
+//
+// It bypasses encoding: raw must already contain valid msgpack encoded values, e.g. bytes received from the server and forwarded by a proxy, or exact byte sequences injected by a test.
+// It is not checked, and invalid bytes will mess up the communication protocol.
+//
+func (mw *Writer) WriteRaw(raw []byte) {
 
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendBool(mw.staging[:0], val)
+	if mw.batching {
+		mw.staging = AppendRaw(mw.staging, raw)
+		return
+	}
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
+	if _, err := mw.bw.Write(raw); err != nil { // in Go, no short write occurs
 		mw.doomed = err
 		return
 	}
 }
 
-func (mw *Writer) WriteUint8(val uint8) {
+// recover_overflow must be deferred by the Write methods that encode a string, byte slice, array or map.
+// If the value is too large, the Append function panics with an OverflowError, which is put in mw.doomed instead of crashing the program.
+// The value is not written, and Flush() will return this error.
+//
+// Other panics are propagated.
+//
+func (mw *Writer) recover_overflow() {
+
+	if r := recover(); r != nil {
+		if e, ok := r.(OverflowError); ok {
+			mw.doomed = e
+			return
+		}
+		panic(r)
+	}
+}
+
+//******************************************************************************
+//         Write methods
+//         they append msgpack encoded value to the internal mw.staging buffer
+//         and write the buffer to the underlying bufio.Writer
+//******************************************************************************
+
+func (mw *Writer) WriteNil() {
 
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendUint8(mw.staging[:0], val)
+	mw.staging = AppendNil(mw.staging_base())
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
+	mw.write_staging()
+}
+
+func (mw *Writer) WriteBool(val bool) {
+
+	if mw.doomed != nil {
 		return
 	}
+
+	mw.staging = AppendBool(mw.staging_base(), val)
+
+	mw.write_staging()
 }
 
-func (mw *Writer) WriteUint16(val uint16) {
+func (mw *Writer) WriteUint8(val uint8) {
 
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendUint16(mw.staging[:0], val)
+	mw.staging = AppendUint8(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
+	mw.write_staging()
+}
+
+func (mw *Writer) WriteUint16(val uint16) {
+
+	if mw.doomed != nil {
 		return
 	}
+
+	mw.staging = AppendUint16(mw.staging_base(), val)
+
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteUint32(val uint32) {
@@ -143,12 +233,9 @@ func (mw *Writer) WriteUint32(val uint32) {
 		return
 	}
 
-	mw.staging = AppendUint32(mw.staging[:0], val)
+	mw.staging = AppendUint32(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteUint64(val uint64) {
@@ -157,12 +244,9 @@ func (mw *Writer) WriteUint64(val uint64) {
 		return
 	}
 
-	mw.staging = AppendUint64(mw.staging[:0], val)
+	mw.staging = AppendUint64(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteInt8(val int8) {
@@ -171,12 +255,9 @@ func (mw *Writer) WriteInt8(val int8) {
 		return
 	}
 
-	mw.staging = AppendInt8(mw.staging[:0], val)
+	mw.staging = AppendInt8(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteInt16(val int16) {
@@ -185,12 +266,9 @@ func (mw *Writer) WriteInt16(val int16) {
 		return
 	}
 
-	mw.staging = AppendInt16(mw.staging[:0], val)
+	mw.staging = AppendInt16(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteInt32(val int32) {
@@ -199,12 +277,9 @@ func (mw *Writer) WriteInt32(val int32) {
 		return
 	}
 
-	mw.staging = AppendInt32(mw.staging[:0], val)
+	mw.staging = AppendInt32(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteInt64(val int64) {
@@ -213,12 +288,9 @@ func (mw *Writer) WriteInt64(val int64) {
 		return
 	}
 
-	mw.staging = AppendInt64(mw.staging[:0], val)
+	mw.staging = AppendInt64(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteFloat32(val float32) {
@@ -227,12 +299,9 @@ func (mw *Writer) WriteFloat32(val float32) {
 		return
 	}
 
-	mw.staging = AppendFloat32(mw.staging[:0], val)
+	mw.staging = AppendFloat32(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteFloat64(val float64) {
@@ -241,54 +310,87 @@ func (mw *Writer) WriteFloat64(val float64) {
 		return
 	}
 
-	mw.staging = AppendFloat64(mw.staging[:0], val)
+	mw.staging = AppendFloat64(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
+// WriteString writes a msgpack string.
+//
+// A string larger than WRITER_LARGE_STRING_THRESHOLD is not copied into staging, which would grow to the size of the string.
+// Only its header is encoded in staging, and the string bytes are written directly to the bufio.Writer.
+//
 func (mw *Writer) WriteString(val string) {
 
+	defer mw.recover_overflow()
+
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendString(mw.staging[:0], val)
-
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
+	if len(val) > WRITER_LARGE_STRING_THRESHOLD && mw.batching == false {
+		mw.write_large_string(len(val), func() (int, error) { return mw.bw.WriteString(val) })
 		return
 	}
+
+	mw.staging = AppendString(mw.staging_base(), val)
+
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteStringFromBytes(val []byte) {
 
+	defer mw.recover_overflow()
+
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendStringFromBytes(mw.staging[:0], val)
+	if len(val) > WRITER_LARGE_STRING_THRESHOLD && mw.batching == false { // same as WriteString
+		mw.write_large_string(len(val), func() (int, error) { return mw.bw.Write(val) })
+		return
+	}
+
+	mw.staging = AppendStringFromBytes(mw.staging_base(), val)
+
+	mw.write_staging()
+}
+
+// write_large_string encodes the header of a string of sz bytes in staging and writes it, and then calls write_body, which writes the string bytes directly to the bufio.Writer.
+// It is used by WriteString and WriteStringFromBytes for strings larger than WRITER_LARGE_STRING_THRESHOLD.
+//
+// If sz is too large, it panics with an OverflowError, which the caller must recover with recover_overflow.
+//
+func (mw *Writer) write_large_string(sz int, write_body func() (int, error)) {
+
+	if sz > size_max {
+		panic(OverflowError("msgp: string too long"))
+	}
+
+	mw.staging = AppendStringHeader(mw.staging[:0], uint32(sz))
 
 	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
 		mw.doomed = err
 		return
 	}
+
+	if _, err := write_body(); err != nil {
+		mw.doomed = err
+		return
+	}
 }
 
 func (mw *Writer) WriteBytes(val []byte) {
 
+	defer mw.recover_overflow()
+
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendBytes(mw.staging[:0], val)
+	mw.staging = AppendBytes(mw.staging_base(), val)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteStringHeader(sz uint32) {
@@ -297,12 +399,9 @@ func (mw *Writer) WriteStringHeader(sz uint32) {
 		return
 	}
 
-	mw.staging = AppendStringHeader(mw.staging[:0], sz)
+	mw.staging = AppendStringHeader(mw.staging_base(), sz)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteBytesHeader(sz uint32) {
@@ -311,12 +410,9 @@ func (mw *Writer) WriteBytesHeader(sz uint32) {
 		return
 	}
 
-	mw.staging = AppendBytesHeader(mw.staging[:0], sz)
+	mw.staging = AppendBytesHeader(mw.staging_base(), sz)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteArrayHeader(sz uint32) {
@@ -325,12 +421,9 @@ func (mw *Writer) WriteArrayHeader(sz uint32) {
 		return
 	}
 
-	mw.staging = AppendArrayHeader(mw.staging[:0], sz)
+	mw.staging = AppendArrayHeader(mw.staging_base(), sz)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteMapHeader(sz uint32) {
@@ -339,68 +432,74 @@ func (mw *Writer) WriteMapHeader(sz uint32) {
 		return
 	}
 
-	mw.staging = AppendMapHeader(mw.staging[:0], sz)
+	mw.staging = AppendMapHeader(mw.staging_base(), sz)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteSimpleType(i interface{}) {
 
+	defer mw.recover_overflow()
+
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendSimpleType(mw.staging[:0], i)
+	mw.staging = AppendSimpleType(mw.staging_base(), i)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
+	mw.write_staging()
+}
+
+func (mw *Writer) WriteArraySimpleType(arg []interface{}) {
+
+	defer mw.recover_overflow()
+
+	if mw.doomed != nil {
 		return
 	}
+
+	mw.staging = AppendArraySimpleType(mw.staging_base(), arg)
+
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteMapStrStr(arg map[string]string) {
 
+	defer mw.recover_overflow()
+
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendMapStrStr(mw.staging[:0], arg)
+	mw.staging = AppendMapStrStr(mw.staging_base(), arg)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteMapStrSimpleType(arg map[string]interface{}) {
 
+	defer mw.recover_overflow()
+
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendMapStrSimpleType(mw.staging[:0], arg)
+	mw.staging = AppendMapStrSimpleType(mw.staging_base(), arg)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 func (mw *Writer) WriteMapStrStrFromList(args ...string) {
 
+	defer mw.recover_overflow()
+
 	if mw.doomed != nil {
 		return
 	}
 
-	mw.staging = AppendMapStrStrFromList(mw.staging[:0], args...)
+	mw.staging = AppendMapStrStrFromList(mw.staging_base(), args...)
 
-	if _, err := mw.bw.Write(mw.staging); err != nil { // in Go, no short write occurs
-		mw.doomed = err
-		return
-	}
+	mw.write_staging()
 }
 
 //******************************************************************************
@@ -408,6 +507,7 @@ func (mw *Writer) WriteMapStrStrFromList(args ...string) {
 //******************************************************************************
 
 // Flush flushes the underlying bufio.Buffer.
+// If the Writer is in batched mode, the staged values are written first, as by EndBatch.
 //
 //    IF AN ERROR IS RETURNED, IT MEANS THE WRITE HAS FAILED BECAUSE CONNECTION HAS FAILED.
 //    This error could have occurred in any previous operation.
@@ -415,6 +515,8 @@ func (mw *Writer) WriteMapStrStrFromList(args ...string) {
 //
 func (mw *Writer) Flush() (doomed error) {
 
+	mw.EndBatch() // write staged values, if in batched mode
+
 	if mw.doomed != nil {
 		return mw.doomed
 	}
@@ -5,10 +5,12 @@
 package rsqlib
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"rsql/msgp"
@@ -51,11 +53,12 @@ const (
 
 // A new Session is created by the Connect function.
 //
-// Once created, the fields of a Session object are NEVER changed.
+// Once created, the fields of a Session object are NEVER changed, except last_activity which is updated atomically.
 //
 type Session struct {
 	login_name    string
 	remote_server string
+	database      string // database requested by the client
 
 	conn net.Conn // golang doc: Multiple goroutines may invoke methods on a Conn simultaneously.
 
@@ -63,8 +66,17 @@ type Session struct {
 	mw      *msgp.Writer
 	mr      *msgp.Reader
 
-	ticker      *time.Ticker
+	ticker      *time.Ticker  // nil if keepalive is disabled
 	ticker_done chan struct{}
+	close_once  sync.Once // ticker_done must be closed only once, even if Close is called multiple times
+
+	last_activity atomic.Int64 // time of the last message sent to the server, in Unix nanoseconds. Updated by the keepalive goroutine too.
+
+	reading atomic.Bool // true while a goroutine reads responses. See Acquire_reader.
+
+	close_lock         sync.Mutex  // protects closed and stop_close_on_done, as Close can be called by the context.AfterFunc goroutine while Close_on_done registers it
+	closed             bool        // set by Close
+	stop_close_on_done func() bool // set by Close_on_done, to release the context.AfterFunc registration when the session is closed. nil if not used.
 }
 
 type Error_info struct {
@@ -151,6 +163,38 @@ func (e Error_info) Line_pos() int64 {
 	return e.line_pos
 }
 
+// Login_error is returned by Connect when the server has rejected the login, e.g. because of bad login name or password.
+// It allows to distinguish authentication failures from network failures.
+//
+// The current server usually closes the connection when login fails, instead of sending RESTYP_LOGIN_FAILED.
+// In this case, the Login_error wraps io.EOF, as the connection may also have been dropped for another reason, e.g. a server restart. errors.Is(err, io.EOF) tells both cases apart.
+//
+type Login_error struct {
+	message string // reason sent by the server, if any. Currently, the server usually sends no reason.
+	err     error  // io.EOF if the server has closed the connection without sending RESTYP_LOGIN_FAILED. Else, nil.
+}
+
+func (e *Login_error) Error() string {
+
+	if e.message == "" {
+		return "Login failed"
+	}
+
+	return "Login failed: " + e.message
+}
+
+// Message returns the reason of the login failure sent by the server, or an empty string if the server sent no reason.
+//
+func (e *Login_error) Message() string {
+	return e.message
+}
+
+// Unwrap returns io.EOF if the server has closed the connection without sending RESTYP_LOGIN_FAILED. Else, it returns nil.
+//
+func (e *Login_error) Unwrap() error {
+	return e.err
+}
+
 type Options struct {
 	Showtree bool // show AST tree
 	No_cf    bool // no constant folding, for debugging
@@ -160,22 +204,118 @@ type Options struct {
 // Connect returns a Session if login has been successful.
 // This Session object contains an open net.Conn connection.
 //
-// If login or connection failed, it just returns an error.
+// If login or connection failed, it just returns an error. If the server has rejected the login, the error is a *Login_error.
 //
 // If no error occurred, a valid Session object is returned. You must call Session.Close() when you are finished with it or if an error occurs during its use.
 //
+// keepalive_interval is in seconds. If 0, no keepalive message is sent, and no goroutine is spawned for it. It is useful for short-lived connections.
+//
+// There is no timeout for the connection and the login handshake. Use ConnectWithTimeout or ConnectContext to limit their duration.
+//
 func Connect(remote_server string, login_name string, password string, database string, opt *Options, keepalive_interval int) (*Session, error) {
+
+	return ConnectContext(context.Background(), remote_server, login_name, password, database, opt, keepalive_interval)
+}
+
+// ConnectWithTimeout is the same as Connect, with a connect timeout.
+//
+// connect_timeout is in seconds. It limits the time to establish the connection and to perform the login handshake, so that an unreachable or half-open server doesn't block the client. If 0, there is no timeout.
+//
+func ConnectWithTimeout(remote_server string, login_name string, password string, database string, opt *Options, keepalive_interval int, connect_timeout int) (*Session, error) {
+
+	ctx := context.Background()
+
+	if connect_timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(connect_timeout)*time.Second)
+		defer cancel()
+	}
+
+	return ConnectContext(ctx, remote_server, login_name, password, database, opt, keepalive_interval)
+}
+
+// ConnectContext is the same as Connect, but the connection and the login handshake can be cancelled by ctx, or limited by its deadline.
+//
+// If ctx is cancelled or its deadline expires before login has succeeded, ctx.Err() is returned.
+// Once the Session has been returned, ctx has no effect on it.
+//
+func ConnectContext(ctx context.Context, remote_server string, login_name string, password string, database string, opt *Options, keepalive_interval int) (*Session, error) {
+	var (
+		err    error
+		conn   net.Conn
+		dialer net.Dialer
+	)
+
+	if conn, err = dialer.DialContext(ctx, "tcp", remote_server); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	return connect_on_conn(ctx, conn, remote_server, login_name, password, database, opt, keepalive_interval)
+}
+
+// ConnectWithConn is the same as Connect, but the login handshake is performed on conn, which is already connected to the server, instead of dialing a TCP connection.
+//
+// It is useful for tests, with a fake server at the other end of a net.Pipe, and to connect through a tunnel, e.g. a connection forwarded by SSH.
+// The returned Session owns conn, which is closed by Session.Close(). If an error occurs, conn is closed.
+//
+// To limit the duration of the login handshake, set a deadline on conn before calling ConnectWithConn. It is removed when login has succeeded.
+//
+func ConnectWithConn(conn net.Conn, login_name string, password string, database string, opt *Options, keepalive_interval int) (*Session, error) {
+
+	remote_server := ""
+	if addr := conn.RemoteAddr(); addr != nil {
+		remote_server = addr.String()
+	}
+
+	return connect_on_conn(context.Background(), conn, remote_server, login_name, password, database, opt, keepalive_interval)
+}
+
+// connect_on_conn performs the login handshake on conn, and returns the Session. The handshake can be cancelled by ctx.
+// If an error occurs, conn is closed.
+//
+func connect_on_conn(ctx context.Context, conn net.Conn, remote_server string, login_name string, password string, database string, opt *Options, keepalive_interval int) (*Session, error) {
 	var (
 		err       error
-		conn      net.Conn
 		mw        *msgp.Writer
 		mr        *msgp.Reader
 		u         uint8
 		resp_type Response_t
 	)
 
-	if conn, err = net.Dial("tcp", remote_server); err != nil {
-		return nil, err
+	//--- apply ctx to the login handshake ---
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	handshake_done := make(chan struct{})
+	watcher_done := make(chan struct{})
+
+	go func() { // if ctx is cancelled during the handshake, unblock the pending read or write
+		defer close(watcher_done)
+
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0)) // deadline in the past
+		case <-handshake_done:
+		}
+	}()
+
+	end_handshake := func(err error) error { // stops the watcher goroutine. If ctx has been cancelled, ctx.Err() replaces err.
+		close(handshake_done)
+		<-watcher_done
+
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
 	}
 
 	mw = msgp.NewWriter(conn)
@@ -205,6 +345,7 @@ func Connect(remote_server string, login_name string, password string, database
 	mw.WriteMapStrSimpleType(auth_message)
 
 	if err = mw.Flush(); err != nil {
+		err = end_handshake(err)
 		conn.Close()
 		return nil, err
 	}
@@ -212,15 +353,40 @@ func Connect(remote_server string, login_name string, password string, database
 	//--- read authentication response ---
 
 	if u, err = mr.ReadUint8(); err != nil {
+		err = end_handshake(err)
 		conn.Close()
+		if err == io.EOF { // server drops the connection when login fails, but it may also be a transient failure
+			return nil, &Login_error{err: io.EOF}
+		}
 		return nil, err
 	}
 
 	resp_type = Response_t(u)
 
 	if resp_type != RESTYP_LOGIN_SUCCESS {
+		login_err := &Login_error{}
+
+		if resp_type == RESTYP_LOGIN_FAILED && mr.Buffered() > 0 { // read the reason, if the server has sent one. Don't wait for it, as the server may just close the connection.
+			if reason, err := mr.ReadSimpleType(); err == nil {
+				if reason, ok := reason.(string); ok {
+					login_err.message = reason
+				}
+			}
+		}
+
+		end_handshake(nil)
+		conn.Close()
+		return nil, login_err
+	}
+
+	if err = end_handshake(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err = conn.SetDeadline(time.Time{}); err != nil { // remove deadline of the login handshake
 		conn.Close()
-		return nil, errors.New("Login failed")
+		return nil, err
 	}
 
 	//--- create session object ---
@@ -228,15 +394,22 @@ func Connect(remote_server string, login_name string, password string, database
 	session := &Session{
 		login_name:    login_name,
 		remote_server: remote_server,
+		database:      database,
 
 		conn: conn,
 		mw:   mw,
 		mr:   mr,
+	}
 
-		ticker:      time.NewTicker(time.Duration(keepalive_interval) * time.Second),
-		ticker_done: make(chan struct{}), // no need to have buffered channel for "done" channels, as close(done) doesn't block
+	session.touch() // login is the first activity
+
+	if keepalive_interval <= 0 { // keepalive is disabled, ticker remains nil
+		return session, nil
 	}
 
+	session.ticker = time.NewTicker(time.Duration(keepalive_interval) * time.Second)
+	session.ticker_done = make(chan struct{}) // no need to have buffered channel for "done" channels, as close(done) doesn't block
+
 	//--- spawn goroutine to send keepalive message ---
 
 	go func(done chan struct{}) { // keep sending keepalive message as long as possible, until session is closed or a connection problem occurs
@@ -260,10 +433,75 @@ func Connect(remote_server string, login_name string, password string, database
 	return session, nil
 }
 
+// Session_info contains information about the session, available after login.
+//
+// The server sends nothing after RESTYP_LOGIN_SUCCESS, so it only contains what the client has sent at login.
+//
+type Session_info struct {
+	Login_name string
+	Database   string // database requested by the client, which can be empty for the default database
+}
+
+// Info returns information about the session.
+//
+func (session *Session) Info() Session_info {
+
+	return Session_info{
+		Login_name: session.login_name,
+		Database:   session.database,
+	}
+}
+
+// touch records that a message has just been sent to the server.
+//
+func (session *Session) touch() {
+
+	session.last_activity.Store(time.Now().UnixNano())
+}
+
+// Last_activity returns the time of the last message successfully sent to the server, which is a batch, a keepalive message, or the login.
+//
+// The server closes connections from which it has received nothing for some time (see drv.SERVER_IDLE_TIMEOUT). So, this time is what decides if the server still considers the session alive.
+// It can be called from any goroutine.
+//
+func (session *Session) Last_activity() time.Time {
+
+	return time.Unix(0, session.last_activity.Load())
+}
+
+// Acquire_reader marks the session as being read by the calling goroutine, until Release_reader is called.
+//
+// Writes are serialized by mw_lock, so that a keepalive message can be sent while a response is read. But reads are not serialized: responses of a batch must be read by one goroutine at a time, else the stream is corrupted.
+// If another goroutine is already reading, an error is returned, and the caller must not read anything.
+//
+func (session *Session) Acquire_reader() error {
+
+	if session.reading.CompareAndSwap(false, true) == false {
+		return fmt.Errorf("rsqlib: concurrent read on the same session, responses must be read by one goroutine at a time.")
+	}
+
+	return nil
+}
+
+// Release_reader ends the read started by Acquire_reader.
+//
+func (session *Session) Release_reader() {
+
+	session.reading.Store(false)
+}
+
 func (session *Session) Mr() *msgp.Reader {
 	return session.mr
 }
 
+// Buffered returns the number of bytes received from the server that have not been read yet, and are pending in the buffer of the Reader.
+//
+// When a batch has terminated (after RESTYP_BATCH_END has been read), it should be 0. Else, the communication protocol is messed up.
+//
+func (session *Session) Buffered() int {
+	return session.mr.Buffered()
+}
+
 // Close closes the session and underlying connection socket.
 //
 // Returns an error if the internal call session.conn.Close() has failed, but it can be ignored, as there is nothing much to do in this case.
@@ -271,17 +509,63 @@ func (session *Session) Mr() *msgp.Reader {
 // To cancel a running query, just call session.Close(). The server will notice that the connection has been closed and will free the resources.
 //
 // This function can be called asynchronously from another goroutine, as it is thread safe and can be called multiple times.
+// It can also be called on a nil Session, and does nothing in this case.
 //
 func (session *Session) Close() error {
 
-	session.ticker.Stop() // release Ticker resources. Stop() can be called by multiple goroutines. NOTE: Stop() doesn't close the channel.
-	close(session.ticker_done) // signal to the goroutine that sends keepalive messages that it can terminate
+	if session == nil {
+		return nil
+	}
+
+	session.close_once.Do(func() {
+		session.close_lock.Lock()
+		session.closed = true
+		stop_close_on_done := session.stop_close_on_done
+		session.close_lock.Unlock()
+
+		if stop_close_on_done != nil {
+			stop_close_on_done() // the context doesn't need to close the session any more
+		}
+
+		if session.ticker != nil { // nil if keepalive is disabled
+			session.ticker.Stop() // release Ticker resources. Stop() can be called by multiple goroutines. NOTE: Stop() doesn't close the channel.
+			close(session.ticker_done) // signal to the goroutine that sends keepalive messages that it can terminate
+		}
+	})
+
+	if session.conn == nil {
+		return nil
+	}
 
 	err := session.conn.Close() // Close() is thread safe. Golang doc: Multiple goroutines may invoke methods on a Conn simultaneously.
 
 	return err
 }
 
+// Close_on_done closes the session when ctx is cancelled or its deadline expires, as by Close: the keepalive goroutine stops and the socket is closed.
+// It is useful for request-scoped connections, so that no goroutine nor socket is leaked when the request is cancelled.
+//
+// ConnectContext uses its context only for the connection and login, as it is usually limited by the connect timeout. Close_on_done binds the session to a context for its whole life.
+// It must be called once, just after the session has been created. If ctx can never be cancelled, or if the session is already closed, it does nothing.
+//
+func (session *Session) Close_on_done(ctx context.Context) {
+
+	if ctx.Done() == nil { // e.g. context.Background()
+		return
+	}
+
+	session.close_lock.Lock()
+	defer session.close_lock.Unlock()
+
+	if session.closed {
+		return
+	}
+
+	session.stop_close_on_done = context.AfterFunc(ctx, func() { // if ctx is already done, the function runs at once in its own goroutine, and Close waits for close_lock
+		session.Close()
+	})
+}
+
 // Send_batch sends a batch SQL text to the server.
 // If it fails, because connection is broken, or data doesn't comply with the communication protocol, an error is returned.
 //
@@ -302,6 +586,8 @@ func (session *Session) Send_batch(batch_text []byte) error {
 		return err
 	}
 
+	session.touch()
+
 	return nil
 }
 
@@ -309,6 +595,8 @@ func (session *Session) Send_batch(batch_text []byte) error {
 //
 // Request must be REQTYP_KEEPALIVE.
 //
+// The server doesn't acknowledge keepalive messages, so they cannot be used to measure the round-trip time. For this, send a batch like "SELECT 1" (see drv.Connection.RoundTrip).
+//
 func (session *Session) Send_special_request(reqtyp Request_t) error {
 
 	if reqtyp != REQTYP_KEEPALIVE {
@@ -324,6 +612,8 @@ func (session *Session) Send_special_request(reqtyp Request_t) error {
 		return err
 	}
 
+	session.touch()
+
 	return nil
 }
 
@@ -344,9 +634,26 @@ func (session *Session) Read_response_type() (Response_t, error) {
 	return Response_t(u), nil
 }
 
+// Peek_response_type returns the type of the next response sent by the server, without consuming it.
+//
+// All response types are sent as positive fixint, that is, a single byte.
+//
+func (session *Session) Peek_response_type() (Response_t, error) {
+	var (
+		err error
+		u   uint8
+	)
+
+	if u, err = session.mr.PeekPositiveFixint(); err != nil {
+		return 0, err
+	}
+
+	return Response_t(u), nil
+}
+
 // Read_Error_info reads error information returned by server.
 //
-// Used to read content of message RESTYP_BATCH_ERROR.
+// Used to read content of message RESTYP_BATCH_ERROR. Fields unknown to the client, sent by a later server version, are skipped.
 //
 func (session *Session) Read_Error_info() (*Error_info, error) {
 	var (
@@ -391,6 +698,8 @@ func (session *Session) Read_Error_info() (*Error_info, error) {
 			error_info.line_no, err = session.mr.ReadInt64()
 		case "line_pos":
 			error_info.line_pos, err = session.mr.ReadInt64()
+		default: // field added by a later server version, its value must be consumed to keep the stream in sync
+			err = session.mr.Skip()
 		}
 
 		if err != nil {
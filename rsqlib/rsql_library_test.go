@@ -0,0 +1,243 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package rsqlib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"rsql/msgp"
+)
+
+func Test_session_close(t *testing.T) {
+	var (
+		session *Session
+	)
+
+	if err := session.Close(); err != nil { // nil session
+		t.Fatalf("%s", err)
+	}
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	session = &Session{
+		conn:        client,
+		ticker:      time.NewTicker(time.Hour),
+		ticker_done: make(chan struct{}),
+	}
+
+	session.Close()
+	session.Close() // must not panic because of double close of ticker_done
+
+	select {
+	case <-session.ticker_done:
+	default:
+		t.Fatalf("ticker_done should be closed")
+	}
+
+	session = &Session{} // keepalive disabled, no connection
+	session.Close()
+}
+
+func Test_session_last_activity(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go io.Copy(io.Discard, server)
+
+	session := &Session{
+		conn: client,
+		mw:   msgp.NewWriter(client),
+	}
+
+	if session.Last_activity().UnixNano() != 0 {
+		t.Fatalf("no activity expected")
+	}
+
+	before := time.Now()
+
+	if err := session.Send_special_request(REQTYP_KEEPALIVE); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if session.Last_activity().Before(before) {
+		t.Fatalf("keepalive should update last activity")
+	}
+
+	last := session.Last_activity()
+	client.Close()
+
+	if err := session.Send_batch([]byte("SELECT 1")); err == nil {
+		t.Fatalf("error expected on closed connection")
+	}
+
+	if session.Last_activity().Equal(last) == false {
+		t.Fatalf("failed write should not update last activity")
+	}
+}
+
+func Test_read_error_info_unknown_field(t *testing.T) {
+	var (
+		bbb []byte
+	)
+
+	bbb = msgp.AppendMapHeader(bbb, 4)
+	bbb = msgp.AppendString(bbb, "message")
+	bbb = msgp.AppendString(bbb, "division by zero")
+	bbb = msgp.AppendString(bbb, "new_field") // unknown field, with a value which is not a scalar
+	bbb = msgp.AppendArraySimpleType(bbb, []interface{}{int64(1), "two"})
+	bbb = msgp.AppendString(bbb, "another_new_field")
+	bbb = msgp.AppendMapStrStr(bbb, map[string]string{"a": "b"})
+	bbb = msgp.AppendString(bbb, "state")
+	bbb = msgp.AppendInt64(bbb, 127)
+	bbb = msgp.AppendUint8(bbb, uint8(RESTYP_BATCH_END))
+
+	session := &Session{mr: msgp.NewReader(bytes.NewReader(bbb))}
+
+	error_info, err := session.Read_Error_info()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if error_info.message != "division by zero" || error_info.state != 127 {
+		t.Fatalf("bad error info %+v", error_info)
+	}
+
+	if resp, err := session.Read_response_type(); err != nil || resp != RESTYP_BATCH_END {
+		t.Fatalf("stream out of sync: %v %v", resp, err)
+	}
+}
+
+func Test_session_close_on_done(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	session := &Session{
+		conn:        client,
+		ticker:      time.NewTicker(time.Hour),
+		ticker_done: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session.Close_on_done(ctx)
+
+	cancel()
+
+	select {
+	case <-session.ticker_done: // keepalive goroutine is signalled to terminate
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session should be closed when context is cancelled")
+	}
+
+	if _, err := client.Write([]byte{0}); err == nil {
+		t.Fatalf("connection should be closed")
+	}
+
+	// closing the session first releases the context registration
+
+	session = &Session{}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	session.Close_on_done(ctx)
+	session.Close()
+
+	if session.stop_close_on_done() { // already stopped by Close
+		t.Fatalf("Close should stop the context registration")
+	}
+
+	// a context already done closes the session from another goroutine, while Close_on_done registers it. Run with -race.
+
+	session = &Session{ticker: time.NewTicker(time.Hour), ticker_done: make(chan struct{})}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	session.Close_on_done(ctx)
+
+	select {
+	case <-session.ticker_done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session should be closed when context is already done")
+	}
+
+	// Close_on_done on a closed session does nothing
+
+	session = &Session{}
+	session.Close()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	session.Close_on_done(ctx)
+
+	if session.stop_close_on_done != nil {
+		t.Fatalf("closed session should not be registered")
+	}
+}
+
+func Test_connect_with_conn(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	auth := make(chan map[string]interface{}, 1)
+
+	go func() { // fake server, accepting the login
+		mr := msgp.NewReader(server)
+		mw := msgp.NewWriter(server)
+
+		if u, err := mr.ReadUint8(); err != nil || Request_t(u) != REQTYP_AUTH {
+			server.Close()
+			return
+		}
+
+		m, err := mr.ReadMapStrIntf()
+		if err != nil {
+			server.Close()
+			return
+		}
+		auth <- m
+
+		mw.WriteUint8(uint8(RESTYP_LOGIN_SUCCESS))
+		mw.Flush()
+		io.Copy(io.Discard, server)
+	}()
+
+	session, err := ConnectWithConn(client, "john", "secret", "mydb", &Options{}, 0)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer session.Close()
+
+	if m := <-auth; m["login_name"] != "john" || m["password"] != "secret" || m["database"] != "mydb" {
+		t.Fatalf("bad auth message %v", m)
+	}
+
+	if info := session.Info(); info.Login_name != "john" || info.Database != "mydb" {
+		t.Fatalf("bad session info %v", info)
+	}
+
+	// login rejected, the server drops the connection
+
+	client2, server2 := net.Pipe()
+
+	go func() {
+		server2.Read(make([]byte, 1000))
+		server2.Close()
+	}()
+
+	if _, err = ConnectWithConn(client2, "john", "bad", "", &Options{}, 0); err == nil {
+		t.Fatalf("login error expected")
+	} else if _, ok := err.(*Login_error); ok == false {
+		t.Fatalf("*Login_error expected, got %T %s", err, err)
+	}
+}
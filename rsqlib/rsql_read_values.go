@@ -13,6 +13,14 @@ import (
 	"rsql/msgp"
 )
 
+// Dtype_t is the datatype of a column, sent by the server in RESTYP_RECORD_LAYOUT.
+//
+// On the wire, the layout of each column is an array whose first element is the datatype, followed by its precision, scale, etc (see new_field).
+// Each value of a record is a single msgpack scalar (int, float, str, bin, bool, ext) or nil for NULL. It is never an array.
+//
+// The server has no array datatype. A column with a datatype unknown to the client, e.g. an array column sent by a later server version, is rejected by new_field with an explicit error,
+// and a value sent as an array is rejected by read_value. The values of such a column cannot be skipped safely, so the connection must be closed.
+//
 type Dtype_t uint8
 
 const (
@@ -36,10 +44,15 @@ const (
 	DTYPE_DATETIME Dtype_t = 21
 )
 
-func assert(val bool) {
-	if val == false {
-		panic("assertion failed")
+// check_array_size returns an error if the size sz of an array sent by the server is not the expected size.
+// This means the communication protocol is messed up.
+//
+func check_array_size(context string, sz uint32, expected uint32) error {
+	if sz != expected {
+		return fmt.Errorf("rsqlib %s: array size %d, expected %d", context, sz, expected)
 	}
+
+	return nil
 }
 
 const (
@@ -142,6 +155,8 @@ type Time struct {
 	Val     time.Time
 }
 
+// Datetime has no Scale field, as the server doesn't send the fractional-second precision declared for the column. Val contains the value with nanosecond precision.
+//
 type Datetime struct {
 	Is_Null bool
 	Val     time.Time
@@ -424,14 +439,14 @@ func (session *Session) Create_colname_list() ([]string, error) {
 		return nil, err
 	}
 
-	colname_list := make([]string, 0, row_size)
+	colname_list := make([]string, row_size) // allocated once, as the column count is known
 
-	for i := 0; i < int(row_size); i++ {
+	for i := range colname_list {
 		if colname, err = session.mr.ReadString(); err != nil {
 			return nil, err
 		}
 
-		colname_list = append(colname_list, colname)
+		colname_list[i] = colname
 	}
 
 	return colname_list, nil
@@ -441,7 +456,9 @@ func (session *Session) Create_colname_list() ([]string, error) {
 
 // new_fields returns a IField object, created by reading from messagepack Reader. It returns e.g. *Int, *Numeric, *Date, etc.
 //
-func new_field(mr *msgp.Reader) (IField, error) {
+// If reuse is not nil and has the same datatype and the same precision, scale, etc as the field read, it is reset to NULL and returned, instead of allocating a new field.
+//
+func new_field(mr *msgp.Reader, reuse IField) (IField, error) {
 	var (
 		err       error
 		sz        uint32
@@ -461,26 +478,47 @@ func new_field(mr *msgp.Reader) (IField, error) {
 
 	switch Dtype_t(u) {
 	case DTYPE_VOID:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_VOID", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Void); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Void{Is_Null: true}, nil
 
 	case DTYPE_BOOLEAN:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_BOOLEAN", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Boolean); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Boolean{Is_Null: true}, nil
 
 	case DTYPE_VARBINARY:
-		assert(sz == 2)
+		if err = check_array_size("new_field DTYPE_VARBINARY", sz, 2); err != nil {
+			return nil, err
+		}
 		if precision, err = mr.ReadUint16(); err != nil {
 			return nil, err
 		}
 
+		if f, ok := reuse.(*Varbinary); ok && f.Precision == precision {
+			f.Is_Null = true
+			return f, nil
+		}
+
 		return &Varbinary{
 			Precision: precision,
 			Is_Null:   true,
 		}, nil
 
 	case DTYPE_VARCHAR:
-		assert(sz == 3)
+		if err = check_array_size("new_field DTYPE_VARCHAR", sz, 3); err != nil {
+			return nil, err
+		}
 		if precision, err = mr.ReadUint16(); err != nil {
 			return nil, err
 		}
@@ -489,6 +527,11 @@ func new_field(mr *msgp.Reader) (IField, error) {
 			return nil, err
 		}
 
+		if f, ok := reuse.(*Varchar); ok && f.Precision == precision && f.Fixlen == fixlen {
+			f.Is_Null = true
+			return f, nil
+		}
+
 		return &Varchar{
 			Precision: precision,
 			Fixlen:    fixlen,
@@ -496,27 +539,59 @@ func new_field(mr *msgp.Reader) (IField, error) {
 		}, nil
 
 	case DTYPE_BIT:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_BIT", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Bit); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Bit{Is_Null: true}, nil
 
 	case DTYPE_TINYINT:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_TINYINT", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Tinyint); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Tinyint{Is_Null: true}, nil
 
 	case DTYPE_SMALLINT:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_SMALLINT", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Smallint); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Smallint{Is_Null: true}, nil
 
 	case DTYPE_INT:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_INT", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Int); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Int{Is_Null: true}, nil
 
 	case DTYPE_BIGINT:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_BIGINT", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Bigint); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Bigint{Is_Null: true}, nil
 
 	case DTYPE_MONEY:
-		assert(sz == 3)
+		if err = check_array_size("new_field DTYPE_MONEY", sz, 3); err != nil {
+			return nil, err
+		}
 		if precision, err = mr.ReadUint16(); err != nil {
 			return nil, err
 		}
@@ -525,6 +600,11 @@ func new_field(mr *msgp.Reader) (IField, error) {
 			return nil, err
 		}
 
+		if f, ok := reuse.(*Money); ok && f.Precision == precision && f.Scale == scale {
+			f.Is_Null = true
+			return f, nil
+		}
+
 		return &Money{
 			Precision: precision,
 			Scale:     scale,
@@ -532,7 +612,9 @@ func new_field(mr *msgp.Reader) (IField, error) {
 		}, nil
 
 	case DTYPE_NUMERIC:
-		assert(sz == 3)
+		if err = check_array_size("new_field DTYPE_NUMERIC", sz, 3); err != nil {
+			return nil, err
+		}
 		if precision, err = mr.ReadUint16(); err != nil {
 			return nil, err
 		}
@@ -541,6 +623,11 @@ func new_field(mr *msgp.Reader) (IField, error) {
 			return nil, err
 		}
 
+		if f, ok := reuse.(*Numeric); ok && f.Precision == precision && f.Scale == scale {
+			f.Is_Null = true
+			return f, nil
+		}
+
 		return &Numeric{
 			Precision: precision,
 			Scale:     scale,
@@ -548,29 +635,65 @@ func new_field(mr *msgp.Reader) (IField, error) {
 		}, nil
 
 	case DTYPE_FLOAT:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_FLOAT", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Float); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Float{Is_Null: true}, nil
 
 	case DTYPE_DATE:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_DATE", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Date); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Date{Is_Null: true}, nil
 
 	case DTYPE_TIME:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_TIME", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Time); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Time{Is_Null: true}, nil
 
 	case DTYPE_DATETIME:
-		assert(sz == 1)
+		if err = check_array_size("new_field DTYPE_DATETIME", sz, 1); err != nil {
+			return nil, err
+		}
+		if f, ok := reuse.(*Datetime); ok {
+			f.Is_Null = true
+			return f, nil
+		}
 		return &Datetime{Is_Null: true}, nil
 
-	default:
-		return nil, errors.New("Unknown datatype received")
+	default: // e.g. an array column, which this client doesn't support
+		return nil, fmt.Errorf("rsqlib new_field: unknown datatype %d received, the server may be more recent than the client. Array columns are not supported.", u)
 	}
 }
 
 // Create_row creates a row from a messagepack Reader.
 //
 func (session *Session) Create_row() ([]IField, error) {
+
+	return session.Create_row_reusing(nil)
+}
+
+// Create_row_reusing is the same as Create_row, but the slice prev and the fields it contains are reused if possible, to avoid allocations.
+//
+// If prev has the same length as the row, the slice is reused. Each field of prev is reused if it has the same datatype and the same precision, scale, etc as the field read.
+// Reused fields are reset to NULL. The buffer of a reused VARCHAR or VARBINARY field is reused when values are read by Fill_row_with_values.
+//
+// It is useful when consecutive recordsets have the same layout. prev must not be used anymore by the caller.
+//
+func (session *Session) Create_row_reusing(prev []IField) ([]IField, error) {
 	var (
 		err      error
 		field    IField
@@ -584,10 +707,14 @@ func (session *Session) Create_row() ([]IField, error) {
 		return nil, err
 	}
 
-	row = make([]IField, row_size)
+	if len(prev) == int(row_size) {
+		row = prev
+	} else {
+		row = make([]IField, row_size)
+	}
 
 	for i := 0; i < int(row_size); i++ {
-		if field, err = new_field(session.mr); err != nil {
+		if field, err = new_field(session.mr, row[i]); err != nil { // row[i] is nil if row has just been created
 			return nil, err
 		}
 
@@ -613,9 +740,11 @@ func (field *Void) read_value(mr *msgp.Reader) error {
 
 	// always NULL
 
-	assert(objtype == msgp.NilType)
+	if objtype != msgp.NilType {
+		return errors.New("rsqlib read_value VOID: value is not NULL")
+	}
 
-	if mr.ReadNil(); err != nil {
+	if err = mr.ReadNil(); err != nil {
 		return err
 	}
 
@@ -638,7 +767,7 @@ func (field *Boolean) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -673,7 +802,7 @@ func (field *Varbinary) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -684,6 +813,10 @@ func (field *Varbinary) read_value(mr *msgp.Reader) error {
 
 	// value
 
+	if objtype != msgp.BinType { // else, ReadBytes would return a confusing bad prefix error
+		return fmt.Errorf("rsqlib read_value VARBINARY: expected bin for VARBINARY column, got %s", objtype)
+	}
+
 	if val, err = mr.ReadBytes(field.Val[:0]); err != nil {
 		return err
 	}
@@ -708,7 +841,7 @@ func (field *Varchar) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -719,6 +852,10 @@ func (field *Varchar) read_value(mr *msgp.Reader) error {
 
 	// value
 
+	if objtype != msgp.StrType { // else, ReadStringAsBytes would return a confusing bad prefix error
+		return fmt.Errorf("rsqlib read_value VARCHAR: expected string for VARCHAR column, got %s", objtype)
+	}
+
 	if val, err = mr.ReadStringAsBytes(field.Val[:0]); err != nil {
 		return err
 	}
@@ -759,7 +896,7 @@ func (field *Bit) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -774,7 +911,9 @@ func (field *Bit) read_value(mr *msgp.Reader) error {
 		return err
 	}
 
-	assert(val <= 1)
+	if val > 1 {
+		return fmt.Errorf("rsqlib read_value BIT: value %d is not 0 or 1", val)
+	}
 
 	field.Is_Null = false
 	field.Val = val
@@ -796,7 +935,7 @@ func (field *Tinyint) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -831,7 +970,7 @@ func (field *Smallint) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -866,7 +1005,7 @@ func (field *Int) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -901,7 +1040,7 @@ func (field *Bigint) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -936,7 +1075,7 @@ func (field *Money) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -971,7 +1110,7 @@ func (field *Numeric) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -1006,7 +1145,7 @@ func (field *Float) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -1045,7 +1184,7 @@ func (field *Date) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -1090,7 +1229,7 @@ func (field *Time) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -1105,7 +1244,9 @@ func (field *Time) read_value(mr *msgp.Reader) error {
 		return err
 	}
 
-	assert(sz == 2)
+	if err = check_array_size("read_value TIME", sz, 2); err != nil {
+		return err
+	}
 
 	if delta_seconds, err = mr.ReadUint32(); err != nil {
 		return err
@@ -1146,7 +1287,7 @@ func (field *Datetime) read_value(mr *msgp.Reader) error {
 	// NULL
 
 	if objtype == msgp.NilType {
-		if mr.ReadNil(); err != nil {
+		if err = mr.ReadNil(); err != nil {
 			return err
 		}
 
@@ -1161,7 +1302,9 @@ func (field *Datetime) read_value(mr *msgp.Reader) error {
 		return err
 	}
 
-	assert(sz == 3)
+	if err = check_array_size("read_value DATETIME", sz, 3); err != nil {
+		return err
+	}
 
 	if delta_days, err = mr.ReadUint32(); err != nil {
 		return err
@@ -1199,7 +1342,9 @@ func (session *Session) Fill_row_with_values(row []IField) error {
 		return err
 	}
 
-	assert(len(row) == int(row_size))
+	if len(row) != int(row_size) {
+		return fmt.Errorf("rsqlib Fill_row_with_values: row size %d, expected %d", row_size, len(row))
+	}
 
 	for _, field := range row {
 		if err := field.read_value(session.mr); err != nil {
@@ -0,0 +1,242 @@
+// Copyright 2017 Nicolas RIESCH
+// Use of this source code is governed by the license found in the LICENCE file.
+
+package rsqlib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"rsql/msgp"
+)
+
+// append_sample_layout appends the layout of a record (INT, VARCHAR(20), NUMERIC(12,2), DATETIME), as sent by the server.
+//
+func append_sample_layout(dest []byte) []byte {
+
+	dest = msgp.AppendArrayHeader(dest, 4)
+
+	dest = msgp.AppendArrayHeader(dest, 1)
+	dest = msgp.AppendUint8(dest, uint8(DTYPE_INT))
+
+	dest = msgp.AppendArrayHeader(dest, 3)
+	dest = msgp.AppendUint8(dest, uint8(DTYPE_VARCHAR))
+	dest = msgp.AppendUint16(dest, 20)
+	dest = msgp.AppendBool(dest, false)
+
+	dest = msgp.AppendArrayHeader(dest, 3)
+	dest = msgp.AppendUint8(dest, uint8(DTYPE_NUMERIC))
+	dest = msgp.AppendUint16(dest, 12)
+	dest = msgp.AppendUint16(dest, 2)
+
+	dest = msgp.AppendArrayHeader(dest, 1)
+	dest = msgp.AppendUint8(dest, uint8(DTYPE_DATETIME))
+
+	return dest
+}
+
+func Test_create_row_reusing(t *testing.T) {
+	var (
+		err  error
+		row  []IField
+		row2 []IField
+		bbb  []byte
+	)
+
+	bbb = append_sample_layout(bbb)
+	bbb = append_sample_layout(bbb)
+	bbb = msgp.AppendArrayHeader(bbb, 1) // different layout
+	bbb = msgp.AppendArrayHeader(bbb, 1)
+	bbb = msgp.AppendUint8(bbb, uint8(DTYPE_BIGINT))
+
+	session := &Session{mr: msgp.NewReader(bytes.NewReader(bbb))}
+
+	if row, err = session.Create_row_reusing(nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	row[0].(*Int).Is_Null = false
+
+	if row2, err = session.Create_row_reusing(row); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for i := range row {
+		if row2[i] != row[i] {
+			t.Fatalf("field %d has not been reused", i)
+		}
+		if row2[i].IsNull() == false {
+			t.Fatalf("field %d has not been reset to NULL", i)
+		}
+	}
+
+	if row2, err = session.Create_row_reusing(row); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if _, ok := row2[0].(*Bigint); len(row2) != 1 || ok == false {
+		t.Fatalf("bad row for different layout")
+	}
+}
+
+func benchmark_create_row(b *testing.B, reuse bool) {
+	var (
+		err error
+		row []IField
+		bbb []byte
+	)
+
+	for i := 0; i < b.N; i++ {
+		bbb = append_sample_layout(bbb)
+	}
+
+	session := &Session{mr: msgp.NewReader(bytes.NewReader(bbb))}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if reuse == false {
+			row = nil
+		}
+
+		if row, err = session.Create_row_reusing(row); err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
+
+func Benchmark_create_row(b *testing.B) {
+
+	benchmark_create_row(b, false)
+}
+
+func Benchmark_create_row_reusing(b *testing.B) {
+
+	benchmark_create_row(b, true)
+}
+
+// Benchmark_create_wide_recordset reads the column names and the layout of a recordset of 500 columns, as done by drv for each recordset.
+//
+func Benchmark_create_wide_recordset(b *testing.B) {
+	var (
+		err error
+		msg []byte
+	)
+
+	const COLUMN_COUNT = 500
+
+	msg = msgp.AppendArrayHeader(msg, COLUMN_COUNT) // column names
+	for i := 0; i < COLUMN_COUNT; i++ {
+		msg = msgp.AppendString(msg, fmt.Sprintf("column_%d", i))
+	}
+
+	msg = msgp.AppendArrayHeader(msg, COLUMN_COUNT) // layout
+	for i := 0; i < COLUMN_COUNT; i++ {
+		if i%2 == 0 {
+			msg = msgp.AppendArrayHeader(msg, 1)
+			msg = msgp.AppendUint8(msg, uint8(DTYPE_INT))
+		} else {
+			msg = msgp.AppendArrayHeader(msg, 3)
+			msg = msgp.AppendUint8(msg, uint8(DTYPE_VARCHAR))
+			msg = msgp.AppendUint16(msg, 20)
+			msg = msgp.AppendBool(msg, false)
+		}
+	}
+
+	var bbb []byte
+	for i := 0; i < b.N; i++ {
+		bbb = append(bbb, msg...)
+	}
+
+	session := &Session{mr: msgp.NewReader(bytes.NewReader(bbb))}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err = session.Create_colname_list(); err != nil {
+			b.Fatalf("%s", err)
+		}
+
+		if _, err = session.Create_row(); err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
+
+func Test_varchar_varbinary_mismatch(t *testing.T) {
+	var (
+		err error
+		bbb []byte
+	)
+
+	bbb = msgp.AppendBytes(bbb, []byte("Hello")) // bin sent for VARCHAR column
+	bbb = msgp.AppendString(bbb, "Hello")        // str sent for VARBINARY column
+
+	mr := msgp.NewReader(bytes.NewReader(bbb))
+
+	if err = (&Varchar{}).read_value(mr); err == nil || strings.Contains(err.Error(), "expected string for VARCHAR") == false {
+		t.Fatalf("VARCHAR: clear error expected, got %v", err)
+	}
+
+	if _, err = mr.ReadBytes(nil); err != nil { // skip value
+		t.Fatalf("%s", err)
+	}
+
+	if err = (&Varbinary{}).read_value(mr); err == nil || strings.Contains(err.Error(), "expected bin for VARBINARY") == false {
+		t.Fatalf("VARBINARY: clear error expected, got %v", err)
+	}
+}
+
+func Test_read_value_corrupt(t *testing.T) {
+
+	fields := []IField{&Boolean{}, &Varbinary{}, &Varchar{}, &Bit{}, &Tinyint{}, &Smallint{}, &Int{}, &Bigint{},
+		&Money{}, &Numeric{}, &Float{}, &Date{}, &Time{}, &Datetime{}}
+
+	for _, field := range fields {
+		for _, bbb := range [][]byte{{0xc1}, {}} { // 0xc1 is never used in msgpack. Empty stream is a short read.
+			mr := msgp.NewReader(bytes.NewReader(bbb))
+
+			if err := field.read_value(mr); err == nil {
+				t.Fatalf("%T: error was expected for value % x", field, bbb)
+			}
+		}
+
+		mr := msgp.NewReader(bytes.NewReader([]byte{msgp.M_NIL}))
+
+		if err := field.read_value(mr); err != nil || field.IsNull() == false {
+			t.Fatalf("%T: NULL expected, got %v", field, err)
+		}
+	}
+}
+
+func Test_array_rejected(t *testing.T) {
+	var (
+		bbb []byte
+	)
+
+	bbb = msgp.AppendArrayHeader(bbb, 1) // layout with one column of unknown datatype, e.g. an array column
+	bbb = msgp.AppendArrayHeader(bbb, 1)
+	bbb = msgp.AppendUint8(bbb, 30)
+
+	session := &Session{mr: msgp.NewReader(bytes.NewReader(bbb))}
+
+	if _, err := session.Create_row(); err == nil || strings.Contains(err.Error(), "unknown datatype 30") == false {
+		t.Fatalf("clear error expected, got %v", err)
+	}
+
+	fields := []IField{&Boolean{}, &Varbinary{}, &Varchar{}, &Bit{}, &Tinyint{}, &Smallint{}, &Int{}, &Bigint{},
+		&Money{}, &Numeric{}, &Float{}, &Date{}, &Time{}, &Datetime{}}
+
+	for _, field := range fields { // value sent as an array
+		bbb = msgp.AppendArrayHeader(nil, 1)
+		bbb = msgp.AppendInt64(bbb, 1)
+
+		if err := field.read_value(msgp.NewReader(bytes.NewReader(bbb))); err == nil {
+			t.Fatalf("%T: error expected for array value", field)
+		}
+	}
+}